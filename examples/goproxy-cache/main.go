@@ -0,0 +1,21 @@
+// Command goproxy-cache runs a caching HTTP proxy on :8080, backed by an
+// in-memory LRU cache bounded to 256MB.
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/elazarl/goproxy"
+	"github.com/elazarl/goproxy/ext/httpcache"
+)
+
+func main() {
+	proxy := goproxy.NewProxyHttpServer()
+	proxy.Verbose = true
+
+	cache := httpcache.NewMemoryCache(256 << 20)
+	httpcache.NewHandler(cache, true).Attach(proxy)
+
+	log.Fatal(http.ListenAndServe(":8080", proxy))
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/elazarl/goproxy"
+)
+
+// TokenValidator validates a bearer token extracted from a
+// Proxy-Authorization: Bearer header and returns the identity it represents
+// (e.g. a JWT's "sub" claim).
+type TokenValidator func(token string) (user string, ok bool)
+
+func bearerUnauthorized(req *http.Request, realm string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusProxyAuthRequired,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    req,
+		Header: http.Header{
+			"Proxy-Authenticate": []string{`Bearer realm=` + realm},
+			"Proxy-Connection":   []string{"close"},
+		},
+		Body:          ioutil.NopCloser(bytes.NewBuffer(unauthorizedMsg)),
+		ContentLength: int64(len(unauthorizedMsg)),
+	}
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(req.Header.Get(proxyAuthorizationHeader), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
+
+// Bearer returns a Bearer HTTP authentication handler for requests. validate
+// is typically backed by a JWT verifier for the identity provider in use.
+func Bearer(realm string, validate TokenValidator) goproxy.ReqHandler {
+	return goproxy.FuncReqHandler(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+		token, ok := bearerToken(req)
+		if !ok {
+			return nil, bearerUnauthorized(req, realm)
+		}
+		user, ok := validate(token)
+		if !ok {
+			return nil, bearerUnauthorized(req, realm)
+		}
+		req.Header.Del(proxyAuthorizationHeader)
+		ctx.User = user
+		return req, nil
+	})
+}
+
+// BearerConnect returns a Bearer HTTP authentication handler for CONNECT
+// requests.
+func BearerConnect(realm string, validate TokenValidator) goproxy.HttpsHandler {
+	return goproxy.FuncHttpsHandler(func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+		token, ok := bearerToken(ctx.Req)
+		if !ok {
+			ctx.Resp = bearerUnauthorized(ctx.Req, realm)
+			return goproxy.RejectConnect, host
+		}
+		user, ok := validate(token)
+		if !ok {
+			ctx.Resp = bearerUnauthorized(ctx.Req, realm)
+			return goproxy.RejectConnect, host
+		}
+		ctx.Req.Header.Del(proxyAuthorizationHeader)
+		ctx.User = user
+		return goproxy.OkConnect, host
+	})
+}
+
+// ProxyBearer forces Bearer authentication before any request to the proxy
+// is processed.
+func ProxyBearer(proxy *goproxy.ProxyHttpServer, realm string, validate TokenValidator) {
+	proxy.OnRequest().Do(Bearer(realm, validate))
+	proxy.OnRequest().HandleConnect(BearerConnect(realm, validate))
+}
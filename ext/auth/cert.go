@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/elazarl/goproxy"
+)
+
+var certUnauthorizedMsg = []byte("407 Proxy Authentication Required")
+
+// CertVerifyFunc maps a verified client certificate to a user, the way
+// AuthWithAddrFunc maps a username/password pair to one. The TLS handshake
+// has already checked the certificate chains to the listener's client CA
+// pool by the time this is called; ok false rejects the request anyway,
+// e.g. because the cert's identity isn't recognized or has been revoked.
+type CertVerifyFunc func(cert *x509.Certificate) (user string, ok bool)
+
+// SubjectDNUsers builds a CertVerifyFunc from a map of certificate subject
+// common names to usernames, for deployments that mint one client cert per
+// user and don't need verification logic beyond the chain validation the
+// TLS handshake already performed.
+func SubjectDNUsers(users map[string]string) CertVerifyFunc {
+	return func(cert *x509.Certificate) (string, bool) {
+		user, ok := users[cert.Subject.CommonName]
+		return user, ok
+	}
+}
+
+func certUnauthorized(req *http.Request) *http.Response {
+	return &http.Response{
+		StatusCode:    407,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Request:       req,
+		Header:        http.Header{"Proxy-Connection": []string{"close"}},
+		Body:          ioutil.NopCloser(bytes.NewBuffer(certUnauthorizedMsg)),
+		ContentLength: int64(len(certUnauthorizedMsg)),
+	}
+}
+
+// certAuth resolves the certificate to verify for ctx from
+// ctx.Req.TLS.PeerCertificates[0]: net/http populates req.TLS for every
+// request served off a TLS-terminated connection, including the one
+// ListenAndServeMTLS opens, with no extra plumbing required.
+func certAuth(ctx *goproxy.ProxyCtx, verify CertVerifyFunc) (string, bool) {
+	if ctx.Req == nil || ctx.Req.TLS == nil || len(ctx.Req.TLS.PeerCertificates) == 0 {
+		return "", false
+	}
+	return verify(ctx.Req.TLS.PeerCertificates[0])
+}
+
+// Cert returns a client-certificate authentication handler for requests.
+// It requires the proxy to be served via ProxyHttpServer.ListenAndServeMTLS,
+// whose TLS-terminated requests carry the verified certificate on
+// ctx.Req.TLS.
+//
+// You probably want to use auth.ProxyCert(proxy, verify) to enable
+// authentication for all proxy activities.
+func Cert(verify CertVerifyFunc) goproxy.ReqHandler {
+	return goproxy.FuncReqHandler(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+		user, ok := certAuth(ctx, verify)
+		if !ok {
+			return nil, certUnauthorized(req)
+		}
+
+		ctx.User = user
+
+		return req, nil
+	})
+}
+
+// CertConnect returns a client-certificate authentication handler for
+// CONNECT requests. It populates ctx.User from verify, the same as Cert.
+//
+// You probably want to use auth.ProxyCert(proxy, verify) to enable
+// authentication for all proxy activities.
+func CertConnect(verify CertVerifyFunc) goproxy.HttpsHandler {
+	return goproxy.FuncHttpsHandler(func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+		user, ok := certAuth(ctx, verify)
+		if !ok {
+			ctx.Resp = certUnauthorized(ctx.Req)
+			return goproxy.RejectConnect, host
+		}
+
+		ctx.User = user
+
+		return goproxy.OkConnect, host
+	})
+}
+
+// ProxyCert forces client-certificate authentication before any request to
+// the proxy is processed.
+func ProxyCert(proxy *goproxy.ProxyHttpServer, verify CertVerifyFunc) {
+	proxy.OnRequest().Do(Cert(verify))
+	proxy.OnRequest().HandleConnect(CertConnect(verify))
+}
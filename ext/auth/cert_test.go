@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/elazarl/goproxy"
+)
+
+func TestSubjectDNUsers(t *testing.T) {
+	verify := SubjectDNUsers(map[string]string{"alice": "alice"})
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}}
+
+	user, ok := verify(cert)
+	if !ok || user != "alice" {
+		t.Fatalf("verify(alice) = (%q, %v), want (alice, true)", user, ok)
+	}
+
+	if _, ok := verify(&x509.Certificate{Subject: pkix.Name{CommonName: "mallory"}}); ok {
+		t.Error("expected unmapped common name to be rejected")
+	}
+}
+
+func TestCertNoClientCert(t *testing.T) {
+	ctx := &goproxy.ProxyCtx{}
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+
+	_, resp := Cert(SubjectDNUsers(nil)).Handle(req, ctx)
+	if resp == nil || resp.StatusCode != 407 {
+		t.Fatalf("expected 407 without a client cert, got %+v", resp)
+	}
+}
+
+func TestCertConnectPopulatesUser(t *testing.T) {
+	req := &http.Request{TLS: &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "alice"}}},
+	}}
+	ctx := &goproxy.ProxyCtx{Req: req}
+
+	action, _ := CertConnect(SubjectDNUsers(map[string]string{"alice": "alice"})).HandleConnect("example.com:443", ctx)
+	if action != goproxy.OkConnect {
+		t.Fatalf("expected OkConnect, got %+v", action)
+	}
+	if ctx.User != "alice" {
+		t.Errorf("ctx.User = %q, want alice", ctx.User)
+	}
+}
+
+// caKeyPair is a minimal self-signed CA used to mint a server certificate and
+// a client certificate for TestCertOverRealMTLSHandshake.
+type caKeyPair struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCA(t *testing.T) *caKeyPair {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating CA key: %v", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating CA certificate: %v", err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parsing CA certificate: %v", err)
+	}
+
+	return &caKeyPair{cert: cert, key: key, der: der}
+}
+
+func (ca *caKeyPair) issue(t *testing.T, commonName string, serial int64, eku x509.ExtKeyUsage) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key for %s: %v", commonName, err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{eku},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("creating certificate for %s: %v", commonName, err)
+	}
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// TestCertOverRealMTLSHandshake drives a genuine mTLS handshake -- a real
+// client certificate, verified by a real tls.Server -- through Cert. It
+// exists to prove the ctx.Req.TLS.PeerCertificates path that
+// ListenAndServeMTLS relies on actually works end to end.
+func TestCertOverRealMTLSHandshake(t *testing.T) {
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, "proxy-server", 2, x509.ExtKeyUsageServerAuth)
+	clientCert := ca.issue(t, "alice", 3, x509.ExtKeyUsageClientAuth)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+
+	var gotUser string
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := &goproxy.ProxyCtx{Req: r}
+		_, resp := Cert(SubjectDNUsers(map[string]string{"alice": "alice"})).Handle(r, ctx)
+		if resp != nil {
+			w.WriteHeader(resp.StatusCode)
+			return
+		}
+		gotUser = ctx.User
+		w.WriteHeader(http.StatusOK)
+	}))
+	srv.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	}
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				Certificates: []tls.Certificate{clientCert},
+				RootCAs:      pool,
+			},
+		},
+	}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET over mTLS: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want 200", resp.StatusCode)
+	}
+	if gotUser != "alice" {
+		t.Errorf("ctx.User = %q, want alice (resolved from the real handshake's PeerCertificates)", gotUser)
+	}
+}
@@ -0,0 +1,20 @@
+package auth
+
+import "path/filepath"
+
+// hostMatchesGlob reports whether host matches pattern, a shell glob as
+// accepted by filepath.Match (e.g. "*.example.com", "sub.example.com").
+func hostMatchesGlob(pattern, host string) bool {
+	matched, err := filepath.Match(pattern, host)
+	return err == nil && matched
+}
+
+// hostMatchesAny reports whether host matches any of patterns.
+func hostMatchesAny(patterns []string, host string) bool {
+	for _, p := range patterns {
+		if hostMatchesGlob(p, host) {
+			return true
+		}
+	}
+	return false
+}
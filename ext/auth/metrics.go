@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync/atomic"
+)
+
+// userBytes is a snapshot of one user's counters, taken under
+// PolicyEnforcer.mu so MetricsHandler can format its response without
+// holding the lock that OnRequest/OnResponse/HandleConnect also serialize
+// on.
+type userBytes struct {
+	user     string
+	bytesIn  int64
+	bytesOut int64
+}
+
+// MetricsHandler returns a Prometheus-compatible http.Handler exposing each
+// user's current byte counters, suitable for mounting on an operator-facing
+// mux (it is not meant to be reachable through the proxy itself).
+func (e *PolicyEnforcer) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		e.mu.Lock()
+		snapshot := make([]userBytes, 0, len(e.usage))
+		for user, usage := range e.usage {
+			snapshot = append(snapshot, userBytes{
+				user:     user,
+				bytesIn:  atomic.LoadInt64(&usage.bytesIn),
+				bytesOut: atomic.LoadInt64(&usage.bytesOut),
+			})
+		}
+		e.mu.Unlock()
+
+		sort.Slice(snapshot, func(i, j int) bool { return snapshot[i].user < snapshot[j].user })
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprintln(w, "# HELP goproxy_user_bytes_in_total Bytes received from the client, per user.")
+		fmt.Fprintln(w, "# TYPE goproxy_user_bytes_in_total counter")
+		for _, u := range snapshot {
+			fmt.Fprintf(w, "goproxy_user_bytes_in_total{user=%q} %d\n", u.user, u.bytesIn)
+		}
+
+		fmt.Fprintln(w, "# HELP goproxy_user_bytes_out_total Bytes sent to the client, per user.")
+		fmt.Fprintln(w, "# TYPE goproxy_user_bytes_out_total counter")
+		for _, u := range snapshot {
+			fmt.Fprintf(w, "goproxy_user_bytes_out_total{user=%q} %d\n", u.user, u.bytesOut)
+		}
+	})
+}
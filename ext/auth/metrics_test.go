@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// blockingResponseWriter wraps an httptest.ResponseRecorder but blocks the
+// first Write until unblock is closed, simulating a slow or stalled
+// scraper reading the metrics response.
+type blockingResponseWriter struct {
+	*httptest.ResponseRecorder
+	unblock chan struct{}
+}
+
+func (w *blockingResponseWriter) Write(b []byte) (int, error) {
+	<-w.unblock
+	return w.ResponseRecorder.Write(b)
+}
+
+// TestMetricsHandlerDoesNotBlockOnSlowWrite proves a stalled response write
+// doesn't hold PolicyEnforcer.mu -- the same lock limiterFor/usageFor take
+// on every OnRequest/OnResponse -- for as long as the write is stuck.
+func TestMetricsHandlerDoesNotBlockOnSlowWrite(t *testing.T) {
+	e := NewPolicyEnforcer()
+	e.usageFor("alice")
+
+	w := &blockingResponseWriter{ResponseRecorder: httptest.NewRecorder(), unblock: make(chan struct{})}
+	go e.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	// Give MetricsHandler's goroutine a chance to reach the blocked Write.
+	time.Sleep(20 * time.Millisecond)
+
+	done := make(chan struct{})
+	go func() {
+		e.usageFor("bob")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("usageFor blocked on a stalled MetricsHandler write -- mu must be released before writing the response")
+	}
+
+	close(w.unblock)
+}
+
+func TestMetricsHandlerFormatsCounters(t *testing.T) {
+	e := NewPolicyEnforcer()
+	usage := e.usageFor("alice")
+	usage.bytesIn = 10
+	usage.bytesOut = 20
+
+	w := httptest.NewRecorder()
+	e.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := w.Body.Bytes()
+	if !bytes.Contains(body, []byte(`goproxy_user_bytes_in_total{user="alice"} 10`)) {
+		t.Errorf("body missing bytes_in line: %s", body)
+	}
+	if !bytes.Contains(body, []byte(`goproxy_user_bytes_out_total{user="alice"} 20`)) {
+		t.Errorf("body missing bytes_out line: %s", body)
+	}
+}
+
+var _ http.ResponseWriter = (*blockingResponseWriter)(nil)
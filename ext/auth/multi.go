@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/elazarl/goproxy"
+)
+
+// SchemeHandler adapts a single authentication scheme (Basic, Digest, NTLM,
+// Bearer, ...) for use with ProxyMulti.
+type SchemeHandler interface {
+	// Name is the scheme token as it appears in Proxy-Authorization, e.g.
+	// "Basic" or "NTLM".
+	Name() string
+
+	// Authenticate inspects req, whose Proxy-Authorization header is
+	// already known to name this scheme. unauthorized is set whenever ok
+	// is false and must be returned to the client as-is: it may be a
+	// final rejection or, for multi-step schemes like NTLM, the next
+	// challenge in the handshake.
+	Authenticate(req *http.Request) (user string, unauthorized *http.Response, ok bool)
+
+	// challenge returns this scheme's Proxy-Authenticate header value,
+	// used to advertise it when no scheme has matched yet.
+	challenge() string
+}
+
+// ConnectSchemeHandler is implemented by a SchemeHandler whose CONNECT
+// handling needs more than Authenticate's reject-or-accept shape -- e.g.
+// NTLM, whose multi-round handshake has to hijack the connection to keep
+// the type-2/type-3 exchange on the same TCP connection rather than close
+// it. MultiConnect prefers AuthenticateConnect over Authenticate for any
+// scheme that implements this.
+type ConnectSchemeHandler interface {
+	AuthenticateConnect(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string)
+}
+
+type basicScheme struct {
+	realm string
+	f     AuthWithAddrFunc
+}
+
+// BasicScheme adapts Basic authentication for use with ProxyMulti.
+func BasicScheme(realm string, f func(user, passwd string) bool) SchemeHandler {
+	return BasicSchemeWithAddr(realm, func(remoteAddr, user, passwd string) (string, string, string, bool) {
+		return remoteAddr, user, passwd, f(user, passwd)
+	})
+}
+
+// BasicSchemeWithAddr is like BasicScheme but lets the authenticator rewrite
+// ProxyCtx.Req.RemoteAddr, mirroring BasicWithAddr.
+func BasicSchemeWithAddr(realm string, f AuthWithAddrFunc) SchemeHandler {
+	return basicScheme{realm: realm, f: f}
+}
+
+func (b basicScheme) Name() string { return "Basic" }
+
+func (b basicScheme) Authenticate(req *http.Request) (string, *http.Response, bool) {
+	remoteAddr, user, pass, ok := auth(req, b.f)
+	if !ok {
+		return "", BasicUnauthorized(req, b.realm), false
+	}
+	req.RemoteAddr = remoteAddr
+	_ = pass
+	return user, nil, true
+}
+
+func (b basicScheme) challenge() string { return "Basic realm=" + b.realm }
+
+type digestScheme struct{ d *DigestAuthenticator }
+
+// DigestScheme adapts a DigestAuthenticator for use with ProxyMulti.
+func DigestScheme(d *DigestAuthenticator) SchemeHandler { return digestScheme{d} }
+
+func (s digestScheme) Name() string { return "Digest" }
+
+func (s digestScheme) Authenticate(req *http.Request) (string, *http.Response, bool) {
+	user, ok := s.d.Authenticate(req)
+	if !ok {
+		return "", s.d.Unauthorized(req), false
+	}
+	req.Header.Del(proxyAuthorizationHeader)
+	return user, nil, true
+}
+
+func (s digestScheme) challenge() string { return s.d.Challenge() }
+
+type ntlmScheme struct{ n *NTLMAuthenticator }
+
+// NTLMScheme adapts an NTLMAuthenticator for use with ProxyMulti.
+func NTLMScheme(n *NTLMAuthenticator) SchemeHandler { return ntlmScheme{n} }
+
+func (s ntlmScheme) Name() string { return "NTLM" }
+
+func (s ntlmScheme) Authenticate(req *http.Request) (string, *http.Response, bool) {
+	user, step, ok := s.n.Authenticate(req)
+	if !ok {
+		return "", ntlmUnauthorized(req, step), false
+	}
+	req.Header.Del(proxyAuthorizationHeader)
+	return user, nil, true
+}
+
+func (s ntlmScheme) challenge() string { return "NTLM" }
+
+// AuthenticateConnect delegates to NTLMConnect's own CONNECT handling, so a
+// type-1 message still gets a ConnectProxyAuthHijack round over the same
+// connection instead of MultiConnect's generic RejectConnect closing it.
+func (s ntlmScheme) AuthenticateConnect(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+	return NTLMConnect(s.n).HandleConnect(host, ctx)
+}
+
+type bearerScheme struct {
+	realm    string
+	validate TokenValidator
+}
+
+// BearerScheme adapts Bearer token authentication for use with ProxyMulti.
+func BearerScheme(realm string, validate TokenValidator) SchemeHandler {
+	return bearerScheme{realm: realm, validate: validate}
+}
+
+func (s bearerScheme) Name() string { return "Bearer" }
+
+func (s bearerScheme) Authenticate(req *http.Request) (string, *http.Response, bool) {
+	token, ok := bearerToken(req)
+	if ok {
+		if user, ok := s.validate(token); ok {
+			req.Header.Del(proxyAuthorizationHeader)
+			return user, nil, true
+		}
+	}
+	return "", bearerUnauthorized(req, s.realm), false
+}
+
+func (s bearerScheme) challenge() string { return "Bearer realm=" + s.realm }
+
+// multiUnauthorized lists every scheme's challenge as a separate
+// Proxy-Authenticate header, per RFC 7235 4.3.
+func multiUnauthorized(req *http.Request, schemes []SchemeHandler) *http.Response {
+	values := make([]string, len(schemes))
+	for i, s := range schemes {
+		values[i] = s.challenge()
+	}
+	return &http.Response{
+		StatusCode: http.StatusProxyAuthRequired,
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    req,
+		Header: http.Header{
+			"Proxy-Authenticate": values,
+			"Proxy-Connection":   []string{"close"},
+		},
+		Body:          ioutil.NopCloser(bytes.NewBuffer(unauthorizedMsg)),
+		ContentLength: int64(len(unauthorizedMsg)),
+	}
+}
+
+func pickScheme(req *http.Request, schemes []SchemeHandler) SchemeHandler {
+	header := req.Header.Get(proxyAuthorizationHeader)
+	if header == "" {
+		return nil
+	}
+	scheme, _, _ := strings.Cut(header, " ")
+	for _, s := range schemes {
+		if strings.EqualFold(scheme, s.Name()) {
+			return s
+		}
+	}
+	return nil
+}
+
+// Multi returns a negotiator that picks an authentication scheme by the
+// Proxy-Authorization header's scheme token and delegates to it, issuing a
+// 407 listing every supported scheme when none matches.
+func Multi(schemes ...SchemeHandler) goproxy.ReqHandler {
+	return goproxy.FuncReqHandler(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+		s := pickScheme(req, schemes)
+		if s == nil {
+			return nil, multiUnauthorized(req, schemes)
+		}
+
+		user, unauthorized, ok := s.Authenticate(req)
+		if !ok {
+			return nil, unauthorized
+		}
+
+		ctx.User = user
+		return req, nil
+	})
+}
+
+// MultiConnect is the CONNECT-request counterpart of Multi.
+func MultiConnect(schemes ...SchemeHandler) goproxy.HttpsHandler {
+	return goproxy.FuncHttpsHandler(func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+		s := pickScheme(ctx.Req, schemes)
+		if s == nil {
+			ctx.Resp = multiUnauthorized(ctx.Req, schemes)
+			return goproxy.RejectConnect, host
+		}
+
+		if cs, ok := s.(ConnectSchemeHandler); ok {
+			return cs.AuthenticateConnect(host, ctx)
+		}
+
+		user, unauthorized, ok := s.Authenticate(ctx.Req)
+		if !ok {
+			ctx.Resp = unauthorized
+			return goproxy.RejectConnect, host
+		}
+
+		ctx.User = user
+		return goproxy.OkConnect, host
+	})
+}
+
+// ProxyMulti forces authentication via any of schemes before any request to
+// the proxy is processed, mirroring ProxyBasic.
+func ProxyMulti(proxy *goproxy.ProxyHttpServer, schemes ...SchemeHandler) {
+	proxy.OnRequest().Do(Multi(schemes...))
+	proxy.OnRequest().HandleConnect(MultiConnect(schemes...))
+}
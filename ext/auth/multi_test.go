@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/elazarl/goproxy"
+)
+
+// TestMultiConnectHijacksNTLMInsteadOfRejecting proves a type-1 NTLM CONNECT
+// routed through MultiConnect still gets NTLMConnect's ConnectProxyAuthHijack
+// round -- not MultiConnect's generic RejectConnect, which would close the
+// connection before the type-2/type-3 round trip NTLM needs ever happens.
+func TestMultiConnectHijacksNTLMInsteadOfRejecting(t *testing.T) {
+	n := NewNTLMAuthenticator(func(msg *NTLMType3Message, challenge [8]byte) (string, bool) {
+		return msg.User, len(msg.NTLMResponse) > 0
+	})
+
+	req := httptest.NewRequest(http.MethodConnect, "https://example.com:443/", nil)
+	req.RemoteAddr = "10.0.0.2:4321"
+	req.Header.Set("Proxy-Authorization", "NTLM "+base64.StdEncoding.EncodeToString(buildNTLMType1()))
+	ctx := &goproxy.ProxyCtx{Req: req}
+
+	action, _ := MultiConnect(NTLMScheme(n)).HandleConnect("example.com:443", ctx)
+	if action.Action != goproxy.ConnectProxyAuthHijack {
+		t.Fatalf("action = %v, want ConnectProxyAuthHijack for a type-1 message", action.Action)
+	}
+	if action.Hijack == nil {
+		t.Fatal("expected a non-nil Hijack func to carry the handshake forward")
+	}
+}
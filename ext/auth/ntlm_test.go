@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elazarl/goproxy"
+)
+
+func buildNTLMType1() []byte {
+	msg := make([]byte, 16)
+	copy(msg[0:8], ntlmSignature)
+	binary.LittleEndian.PutUint32(msg[8:12], 1)
+	binary.LittleEndian.PutUint32(msg[12:16], 0xA0088207)
+	return msg
+}
+
+func buildNTLMType3(domain, user, workstation string, ntlmResponse []byte) []byte {
+	encode := func(s string) []byte {
+		b := make([]byte, len(s)*2)
+		for i, r := range s {
+			binary.LittleEndian.PutUint16(b[i*2:i*2+2], uint16(r))
+		}
+		return b
+	}
+
+	domainBytes := encode(domain)
+	userBytes := encode(user)
+	workstationBytes := encode(workstation)
+
+	header := 64
+	offset := header
+	writeField := func(buf []byte, pos int, data []byte) {
+		binary.LittleEndian.PutUint16(buf[pos:pos+2], uint16(len(data)))
+		binary.LittleEndian.PutUint16(buf[pos+2:pos+4], uint16(len(data)))
+		binary.LittleEndian.PutUint32(buf[pos+4:pos+8], uint32(offset))
+		offset += len(data)
+	}
+
+	var body []byte
+	body = append(body, domainBytes...)
+	body = append(body, userBytes...)
+	body = append(body, workstationBytes...)
+	body = append(body, ntlmResponse...)
+
+	buf := make([]byte, header+len(body))
+	copy(buf[0:8], ntlmSignature)
+	binary.LittleEndian.PutUint32(buf[8:12], 3)
+
+	offset = header + len(domainBytes) + len(userBytes) + len(workstationBytes)
+	writeField(buf, 12, nil)          // LM response: empty
+	writeField(buf, 20, ntlmResponse) // NTLM response
+
+	offset = header
+	writeField(buf, 28, domainBytes)
+	writeField(buf, 36, userBytes)
+	writeField(buf, 44, workstationBytes)
+
+	copy(buf[header:], body)
+	return buf
+}
+
+func TestParseNTLMType1(t *testing.T) {
+	msg, err := parseNTLMType1(buildNTLMType1())
+	if err != nil {
+		t.Fatalf("parseNTLMType1: %s", err)
+	}
+	if msg.Flags != 0xA0088207 {
+		t.Errorf("Flags = %#x, want %#x", msg.Flags, 0xA0088207)
+	}
+}
+
+// TestNTLMConnectHijackHandlesNonPreemptiveClient drives the hijack when
+// the client doesn't proactively send a type-1 message on the very first
+// CONNECT -- the common case, since a client has no way to know NTLM is
+// required until challenged. hijackConnect must issue its own type-2
+// challenge for that type-1 and keep the handshake going, rather than
+// treating the non-terminal ok=false it gets back from Authenticate as a
+// hard failure.
+func TestNTLMConnectHijackHandlesNonPreemptiveClient(t *testing.T) {
+	n := NewNTLMAuthenticator(func(msg *NTLMType3Message, challenge [8]byte) (string, bool) {
+		return msg.User, len(msg.NTLMResponse) > 0
+	})
+
+	remoteAddr := "10.0.0.3:5555"
+	req1 := httptest.NewRequest(http.MethodConnect, "https://example.com:443/", nil)
+	req1.RemoteAddr = remoteAddr
+
+	upstreamClient, upstreamServer := net.Pipe()
+	defer upstreamClient.Close()
+	proxy := &goproxy.ProxyHttpServer{
+		ConnectDialWithReq: func(r *http.Request, network, addr string) (net.Conn, error) {
+			return upstreamServer, nil
+		},
+	}
+	ctx := &goproxy.ProxyCtx{Req: req1, Proxy: proxy}
+
+	action, _ := NTLMConnect(n).HandleConnect("example.com:443", ctx)
+	if action.Action != goproxy.ConnectProxyAuthHijack {
+		t.Fatalf("action = %v, want ConnectProxyAuthHijack for an unauthenticated CONNECT", action.Action)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		serverConn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n"))
+		action.Hijack(req1, serverConn, ctx)
+	}()
+
+	br := bufio.NewReader(clientConn)
+	bareChallenge, err := http.ReadResponse(br, req1)
+	if err != nil {
+		t.Fatalf("reading bare 407: %v", err)
+	}
+	if bareChallenge.Header.Get("Proxy-Authenticate") != "NTLM" {
+		t.Fatalf("Proxy-Authenticate = %q, want bare NTLM", bareChallenge.Header.Get("Proxy-Authenticate"))
+	}
+
+	type1 := "CONNECT example.com:443 HTTP/1.1\r\n" +
+		"Host: example.com:443\r\n" +
+		"Proxy-Authorization: NTLM " + base64.StdEncoding.EncodeToString(buildNTLMType1()) + "\r\n\r\n"
+	if _, err := clientConn.Write([]byte(type1)); err != nil {
+		t.Fatalf("writing type-1 CONNECT: %v", err)
+	}
+
+	challenge, err := http.ReadResponse(br, req1)
+	if err != nil {
+		t.Fatalf("reading type-2 challenge response: %v", err)
+	}
+	if challenge.StatusCode != http.StatusProxyAuthRequired {
+		t.Fatalf("status = %d, want 407", challenge.StatusCode)
+	}
+	scheme, encodedStep, found := strings.Cut(challenge.Header.Get("Proxy-Authenticate"), " ")
+	if !found || !strings.EqualFold(scheme, "NTLM") || encodedStep == "" {
+		t.Fatalf("Proxy-Authenticate = %q, want a non-empty NTLM challenge", challenge.Header.Get("Proxy-Authenticate"))
+	}
+
+	type3 := buildNTLMType3("WORKGROUP", "carol", "CAROLS-PC", []byte{5, 6, 7, 8})
+	next := "CONNECT example.com:443 HTTP/1.1\r\n" +
+		"Host: example.com:443\r\n" +
+		"Proxy-Authorization: NTLM " + base64.StdEncoding.EncodeToString(type3) + "\r\n\r\n"
+	if _, err := clientConn.Write([]byte(next)); err != nil {
+		t.Fatalf("writing type-3 CONNECT: %v", err)
+	}
+
+	status, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading tunnel-established status line: %v", err)
+	}
+	if !strings.Contains(status, "200") {
+		t.Fatalf("status line = %q, want 200 Connection Established", status)
+	}
+
+	if ctx.User != "carol" {
+		t.Errorf("ctx.User = %q, want carol", ctx.User)
+	}
+}
+
+func TestNTLMAuthenticateHandshake(t *testing.T) {
+	var gotUser, gotDomain string
+	n := NewNTLMAuthenticator(func(msg *NTLMType3Message, challenge [8]byte) (string, bool) {
+		gotUser, gotDomain = msg.User, msg.Domain
+		return msg.User, len(msg.NTLMResponse) > 0
+	})
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("Proxy-Authorization", "NTLM "+base64.StdEncoding.EncodeToString(buildNTLMType1()))
+
+	_, step, ok := n.Authenticate(req)
+	if ok || step == nil {
+		t.Fatalf("expected a type-2 challenge after type-1, got ok=%v step=%v", ok, step)
+	}
+
+	req2 := httptest.NewRequest("GET", "http://example.com/", nil)
+	req2.RemoteAddr = "10.0.0.1:1234"
+	req2.Header.Set("Proxy-Authorization", "NTLM "+base64.StdEncoding.EncodeToString(
+		buildNTLMType3("WORKGROUP", "bob", "BOBS-PC", []byte{1, 2, 3, 4})))
+
+	user, _, ok := n.Authenticate(req2)
+	if !ok || user != "bob" {
+		t.Fatalf("Authenticate(type3) = (%q, %v), want (bob, true)", user, ok)
+	}
+	if gotUser != "bob" || gotDomain != "WORKGROUP" {
+		t.Errorf("validator saw user=%q domain=%q, want bob/WORKGROUP", gotUser, gotDomain)
+	}
+}
+
+// TestNTLMConnectHijacksAndRelaysAfterHandshake drives NTLMConnect's
+// ConnectProxyAuthHijack path over a real net.Conn pair end to end: a
+// type-1 CONNECT gets a hijack carrying the type-2 challenge, a type-3
+// CONNECT sent over that same connection gets authenticated, and the tunnel
+// is then relayed to the dialed upstream -- proving the handshake survives
+// past the first response, which RejectConnect could never do.
+func TestNTLMConnectHijacksAndRelaysAfterHandshake(t *testing.T) {
+	n := NewNTLMAuthenticator(func(msg *NTLMType3Message, challenge [8]byte) (string, bool) {
+		return msg.User, len(msg.NTLMResponse) > 0
+	})
+
+	remoteAddr := "10.0.0.2:4321"
+	req1 := httptest.NewRequest(http.MethodConnect, "https://example.com:443/", nil)
+	req1.RemoteAddr = remoteAddr
+	req1.Header.Set("Proxy-Authorization", "NTLM "+base64.StdEncoding.EncodeToString(buildNTLMType1()))
+
+	upstreamClient, upstreamServer := net.Pipe()
+	defer upstreamClient.Close()
+	proxy := &goproxy.ProxyHttpServer{
+		ConnectDialWithReq: func(r *http.Request, network, addr string) (net.Conn, error) {
+			if addr != "example.com:443" {
+				t.Errorf("dialed %q, want example.com:443", addr)
+			}
+			return upstreamServer, nil
+		},
+	}
+	ctx := &goproxy.ProxyCtx{Req: req1, Proxy: proxy}
+
+	action, host := NTLMConnect(n).HandleConnect("example.com:443", ctx)
+	if action.Action != goproxy.ConnectProxyAuthHijack {
+		t.Fatalf("action = %v, want ConnectProxyAuthHijack for a type-1 message", action.Action)
+	}
+	if host != "example.com:443" {
+		t.Errorf("host = %q, want example.com:443", host)
+	}
+
+	clientConn, serverConn := net.Pipe()
+	go func() {
+		// goproxy itself writes this status line before invoking Hijack;
+		// reproduce that here since this test drives Hijack directly.
+		serverConn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n"))
+		action.Hijack(req1, serverConn, ctx)
+	}()
+
+	br := bufio.NewReader(clientConn)
+	resp, err := http.ReadResponse(br, req1)
+	if err != nil {
+		t.Fatalf("reading type-2 challenge response: %v", err)
+	}
+	if resp.StatusCode != http.StatusProxyAuthRequired {
+		t.Fatalf("status = %d, want 407", resp.StatusCode)
+	}
+	scheme, encodedStep, found := strings.Cut(resp.Header.Get("Proxy-Authenticate"), " ")
+	if !found || !strings.EqualFold(scheme, "NTLM") || encodedStep == "" {
+		t.Fatalf("Proxy-Authenticate = %q, want a non-empty NTLM challenge", resp.Header.Get("Proxy-Authenticate"))
+	}
+
+	type3 := buildNTLMType3("WORKGROUP", "alice", "ALICE-PC", []byte{9, 9, 9, 9})
+	next := "CONNECT example.com:443 HTTP/1.1\r\n" +
+		"Host: example.com:443\r\n" +
+		"Proxy-Authorization: NTLM " + base64.StdEncoding.EncodeToString(type3) + "\r\n\r\n"
+	if _, err := clientConn.Write([]byte(next)); err != nil {
+		t.Fatalf("writing type-3 CONNECT: %v", err)
+	}
+
+	status, err := br.ReadString('\n')
+	if err != nil {
+		t.Fatalf("reading tunnel-established status line: %v", err)
+	}
+	if !strings.Contains(status, "200") {
+		t.Fatalf("status line = %q, want 200 Connection Established", status)
+	}
+	if _, err := br.ReadString('\n'); err != nil {
+		t.Fatalf("reading blank line after 200: %v", err)
+	}
+
+	if ctx.User != "alice" {
+		t.Errorf("ctx.User = %q, want alice", ctx.User)
+	}
+
+	if _, err := clientConn.Write([]byte("ping")); err != nil {
+		t.Fatalf("writing tunnel payload: %v", err)
+	}
+	buf := make([]byte, 4)
+	upstreamClient.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := io.ReadFull(upstreamClient, buf); err != nil {
+		t.Fatalf("reading relayed payload at upstream: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("upstream saw %q, want ping", buf)
+	}
+}
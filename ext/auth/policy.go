@@ -0,0 +1,398 @@
+package auth
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/elazarl/goproxy"
+)
+
+// UserPolicy describes the proxy behavior that applies to one authenticated
+// user, as returned alongside a successful AuthWithPolicyFunc call.
+type UserPolicy struct {
+	// Upstream, if set, is the proxy all of this user's traffic is
+	// tunneled through, including CONNECT requests via a nested dial.
+	Upstream *url.URL
+
+	// AllowedHosts, if non-empty, restricts requests to hosts matching at
+	// least one of these globs (e.g. "*.example.com").
+	AllowedHosts []string
+
+	// DeniedHosts rejects requests to hosts matching any of these globs,
+	// checked after AllowedHosts.
+	DeniedHosts []string
+
+	// BytesInQuota and BytesOutQuota cap the cumulative bytes this user
+	// may send to, and receive from, upstream; zero means unlimited.
+	BytesInQuota  int64
+	BytesOutQuota int64
+
+	// RequestsPerSecond throttles this user's requests with a token
+	// bucket; zero means unlimited. Burst defaults to 1 when unset.
+	RequestsPerSecond float64
+	Burst             int
+
+	// DisableMITM opts this user's CONNECT traffic out of TLS MITM,
+	// regardless of the proxy's default.
+	DisableMITM bool
+}
+
+// AuthWithPolicyFunc is an external authenticator contract like
+// AuthWithAddrFunc, extended to also select a *UserPolicy for the
+// authenticated user. A nil policy means no policy is enforced.
+type AuthWithPolicyFunc func(
+	RemoteAddr string,
+	user string,
+	passwd string,
+) (
+	updatedRemoteAddr string,
+	updatedUser string,
+	updatedPasswd string,
+	policy *UserPolicy,
+	ok bool,
+)
+
+func authWithPolicy(req *http.Request, f AuthWithPolicyFunc) (string, string, string, *UserPolicy, bool) {
+	authheader := strings.SplitN(req.Header.Get(proxyAuthorizationHeader), " ", 2)
+	req.Header.Del(proxyAuthorizationHeader)
+	if len(authheader) != 2 || authheader[0] != "Basic" {
+		return "", "", "", nil, false
+	}
+	userpassraw, err := base64.StdEncoding.DecodeString(authheader[1])
+	if err != nil {
+		return "", "", "", nil, false
+	}
+	userpass := strings.SplitN(string(userpassraw), ":", 2)
+	if len(userpass) != 2 {
+		return "", "", "", nil, false
+	}
+	return f(req.RemoteAddr, userpass[0], userpass[1])
+}
+
+// BasicWithPolicy is like BasicWithAddr, but also attaches the returned
+// *UserPolicy to ctx.UserData, where a PolicyEnforcer installed downstream
+// picks it up.
+func BasicWithPolicy(realm string, f AuthWithPolicyFunc) goproxy.ReqHandler {
+	return goproxy.FuncReqHandler(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+		remoteAddr, user, pass, policy, ok := authWithPolicy(req, f)
+		if !ok {
+			return nil, BasicUnauthorized(req, realm)
+		}
+
+		ctx.Req.RemoteAddr = remoteAddr
+		ctx.User = user
+		ctx.Password = pass
+		ctx.UserData = policy
+
+		return req, nil
+	})
+}
+
+// BasicConnectWithPolicy is the CONNECT counterpart of BasicWithPolicy.
+func BasicConnectWithPolicy(realm string, f AuthWithPolicyFunc) goproxy.HttpsHandler {
+	return goproxy.FuncHttpsHandler(func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+		remoteAddr, user, pass, policy, ok := authWithPolicy(ctx.Req, f)
+		if !ok {
+			ctx.Resp = BasicUnauthorized(ctx.Req, realm)
+			return goproxy.RejectConnect, host
+		}
+
+		ctx.Req.RemoteAddr = remoteAddr
+		ctx.User = user
+		ctx.Password = pass
+		ctx.UserData = policy
+
+		return goproxy.OkConnect, host
+	})
+}
+
+// ProxyBasicWithPolicy forces HTTP authentication before any request to the
+// proxy is processed, additionally enforcing the per-user policy returned by
+// f via a PolicyEnforcer.
+func ProxyBasicWithPolicy(proxy *goproxy.ProxyHttpServer, realm string, f AuthWithPolicyFunc, enforcer *PolicyEnforcer) {
+	proxy.OnRequest().Do(BasicWithPolicy(realm, f))
+	basicConnect := BasicConnectWithPolicy(realm, f)
+	proxy.OnRequest().HandleConnect(goproxy.FuncHttpsHandler(func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+		if action, newHost := basicConnect.HandleConnect(host, ctx); action != goproxy.OkConnect {
+			return action, newHost
+		}
+		return enforcer.HandleConnect(host, ctx)
+	}))
+	enforcer.Attach(proxy)
+}
+
+// userUsage holds the running byte counters for one user.
+type userUsage struct {
+	bytesIn  int64
+	bytesOut int64
+}
+
+// pendingUpstream records the per-user upstream a CONNECT is destined for,
+// between HandleConnect choosing it and the server's ConnectDialWithReq
+// actually dialing it -- ProxyHttpServer.ConnectDialWithReq only receives
+// the *http.Request, not the ctx.UserData a PolicyEnforcer reasons about.
+type pendingUpstream struct {
+	upstream *url.URL
+	user     string
+}
+
+// PolicyEnforcer applies the *UserPolicy stashed on ctx.UserData by an
+// authenticator such as BasicWithPolicy: rate limiting, host allow/deny
+// lists, upstream tunneling, and per-user byte accounting.
+type PolicyEnforcer struct {
+	mu       sync.Mutex
+	limiters map[string]*tokenBucket
+	usage    map[string]*userUsage
+	pending  map[*http.Request]pendingUpstream
+}
+
+// NewPolicyEnforcer returns a ready-to-use PolicyEnforcer.
+func NewPolicyEnforcer() *PolicyEnforcer {
+	return &PolicyEnforcer{
+		limiters: make(map[string]*tokenBucket),
+		usage:    make(map[string]*userUsage),
+		pending:  make(map[*http.Request]pendingUpstream),
+	}
+}
+
+// Attach installs e on proxy's request/response pipeline, and routes CONNECT
+// dialing through e.connectDial so a per-user Upstream chosen in
+// HandleConnect is actually honored. Attach does not register HandleConnect
+// itself; ProxyBasicWithPolicy runs it after the Basic CONNECT check.
+func (e *PolicyEnforcer) Attach(proxy *goproxy.ProxyHttpServer) {
+	proxy.OnRequest().DoFunc(e.OnRequest)
+	proxy.OnResponse().DoFunc(e.OnResponse)
+	proxy.ConnectDialWithReq = e.connectDial
+}
+
+func (e *PolicyEnforcer) limiterFor(user string, policy *UserPolicy) *tokenBucket {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	limiter, ok := e.limiters[user]
+	if !ok {
+		limiter = newTokenBucket(policy.RequestsPerSecond, policy.Burst)
+		e.limiters[user] = limiter
+	}
+	return limiter
+}
+
+func (e *PolicyEnforcer) usageFor(user string) *userUsage {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	usage, ok := e.usage[user]
+	if !ok {
+		usage = &userUsage{}
+		e.usage[user] = usage
+	}
+	return usage
+}
+
+// OnRequest enforces rate limiting, host allow/deny lists, upstream
+// tunneling, and request-body byte accounting for the policy attached to
+// ctx, if any.
+func (e *PolicyEnforcer) OnRequest(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+	policy, ok := ctx.UserData.(*UserPolicy)
+	if !ok || policy == nil {
+		return req, nil
+	}
+
+	if policy.RequestsPerSecond > 0 {
+		if !e.limiterFor(ctx.User, policy).Allow() {
+			return req, goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusTooManyRequests, "rate limit exceeded")
+		}
+	}
+
+	host := req.URL.Hostname()
+	if len(policy.AllowedHosts) > 0 && !hostMatchesAny(policy.AllowedHosts, host) {
+		return req, goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusForbidden, "host not allowed")
+	}
+	if hostMatchesAny(policy.DeniedHosts, host) {
+		return req, goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusForbidden, "host denied")
+	}
+
+	usage := e.usageFor(ctx.User)
+	if req.Body != nil {
+		req.Body = &countingReadCloser{ReadCloser: req.Body, counter: &usage.bytesIn}
+	}
+
+	if policy.Upstream != nil {
+		ctx.RoundTripper = goproxy.RoundTripperFunc(func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Response, error) {
+			transport := &http.Transport{Proxy: http.ProxyURL(policy.Upstream)}
+			return transport.RoundTrip(req)
+		})
+	}
+
+	return req, nil
+}
+
+// OnResponse counts response body bytes towards the user's byte-out
+// quota, and rejects the response with a 507 once either quota has been
+// exceeded.
+func (e *PolicyEnforcer) OnResponse(res *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
+	policy, ok := ctx.UserData.(*UserPolicy)
+	if !ok || policy == nil || res == nil {
+		return res
+	}
+
+	usage := e.usageFor(ctx.User)
+
+	if policy.BytesInQuota > 0 && atomic.LoadInt64(&usage.bytesIn) > policy.BytesInQuota {
+		return goproxy.NewResponse(res.Request, goproxy.ContentTypeText, http.StatusInsufficientStorage, "bytes-in quota exceeded")
+	}
+	if policy.BytesOutQuota > 0 && atomic.LoadInt64(&usage.bytesOut) > policy.BytesOutQuota {
+		return goproxy.NewResponse(res.Request, goproxy.ContentTypeText, http.StatusInsufficientStorage, "bytes-out quota exceeded")
+	}
+
+	if res.Body != nil {
+		res.Body = &countingReadCloser{ReadCloser: res.Body, counter: &usage.bytesOut}
+	}
+
+	return res
+}
+
+// HandleConnect enforces host allow/deny lists for CONNECT requests and, for
+// users with an upstream configured, tunnels the CONNECT through it via a
+// nested dial instead of connecting to host directly.
+func (e *PolicyEnforcer) HandleConnect(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+	policy, ok := ctx.UserData.(*UserPolicy)
+	if !ok || policy == nil {
+		return goproxy.OkConnect, host
+	}
+
+	if len(policy.AllowedHosts) > 0 && !hostMatchesAny(policy.AllowedHosts, host) {
+		ctx.Resp = goproxy.NewResponse(ctx.Req, goproxy.ContentTypeText, http.StatusForbidden, "host not allowed")
+		return goproxy.RejectConnect, host
+	}
+	if hostMatchesAny(policy.DeniedHosts, host) {
+		ctx.Resp = goproxy.NewResponse(ctx.Req, goproxy.ContentTypeText, http.StatusForbidden, "host denied")
+		return goproxy.RejectConnect, host
+	}
+
+	action := *goproxy.MitmConnect
+	if policy.DisableMITM {
+		action = *goproxy.OkConnect
+	}
+
+	// Only a non-MITM'd CONNECT actually dials through
+	// proxy.ConnectDialWithReq (e.connectDial), so only that case needs a
+	// pendingUpstream entry. A MITM'd CONNECT never reaches connectDial --
+	// OnRequest already re-routes every decrypted request on the tunnel via
+	// ctx.RoundTripper -- so stashing one here would never be read back,
+	// leaking one *http.Request-keyed entry per request forever.
+	if policy.Upstream != nil && policy.DisableMITM {
+		e.mu.Lock()
+		e.pending[ctx.Req] = pendingUpstream{upstream: policy.Upstream, user: ctx.User}
+		e.mu.Unlock()
+	}
+
+	return &action, host
+}
+
+// connectDial is installed as proxy.ConnectDialWithReq. For a CONNECT whose
+// HandleConnect stashed a pendingUpstream, it tunnels through that upstream
+// instead of dialing host directly; otherwise it falls back to a direct
+// dial, same as the server's default.
+func (e *PolicyEnforcer) connectDial(req *http.Request, network, addr string) (net.Conn, error) {
+	e.mu.Lock()
+	pu, ok := e.pending[req]
+	if ok {
+		delete(e.pending, req)
+	}
+	e.mu.Unlock()
+
+	if !ok {
+		return net.Dial(network, addr)
+	}
+
+	return nestedConnectDialer(pu.upstream, e.usageFor(pu.user))(network, addr)
+}
+
+// nestedConnectDialer returns a dial function that tunnels through upstream
+// by issuing a CONNECT request over a freshly dialed connection to it -- the
+// same "nested dial" goproxy itself would use to reach the real origin --
+// and wraps the resulting connection so tunneled bytes count against usage.
+func nestedConnectDialer(upstream *url.URL, usage *userUsage) func(network, addr string) (net.Conn, error) {
+	return func(network, addr string) (net.Conn, error) {
+		conn, err := net.Dial(network, upstream.Host)
+		if err != nil {
+			return nil, err
+		}
+
+		req := &http.Request{
+			Method: "CONNECT",
+			URL:    &url.URL{Opaque: addr},
+			Host:   addr,
+			Header: make(http.Header),
+		}
+		if upstream.User != nil {
+			if pass, ok := upstream.User.Password(); ok {
+				req.SetBasicAuth(upstream.User.Username(), pass)
+			}
+		}
+
+		if err := req.Write(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			conn.Close()
+			return nil, fmt.Errorf("auth: upstream proxy %s refused CONNECT %s: %s", upstream.Host, addr, resp.Status)
+		}
+
+		return &countingConn{Conn: conn, usage: usage}, nil
+	}
+}
+
+// countingConn wraps a tunneled net.Conn so that bytes read from it (served
+// to the client) and written to it (sent by the client) count against a
+// user's byte-out and byte-in usage respectively.
+type countingConn struct {
+	net.Conn
+	usage *userUsage
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		atomic.AddInt64(&c.usage.bytesOut, int64(n))
+	}
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		atomic.AddInt64(&c.usage.bytesIn, int64(n))
+	}
+	return n, err
+}
+
+// countingReadCloser tallies every byte read through it into counter.
+type countingReadCloser struct {
+	io.ReadCloser
+	counter *int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	atomic.AddInt64(c.counter, int64(n))
+	return n, err
+}
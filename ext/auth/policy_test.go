@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+
+	"github.com/elazarl/goproxy"
+)
+
+func TestPolicyEnforcerHostDenied(t *testing.T) {
+	e := NewPolicyEnforcer()
+	policy := &UserPolicy{DeniedHosts: []string{"*.blocked.example"}}
+
+	req := httptest.NewRequest("GET", "http://evil.blocked.example/", nil)
+	ctx := &goproxy.ProxyCtx{User: "alice", UserData: policy}
+
+	_, resp := e.OnRequest(req, ctx)
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for denied host, got %+v", resp)
+	}
+}
+
+func TestPolicyEnforcerHostNotInAllowlist(t *testing.T) {
+	e := NewPolicyEnforcer()
+	policy := &UserPolicy{AllowedHosts: []string{"*.allowed.example"}}
+
+	req := httptest.NewRequest("GET", "http://other.example/", nil)
+	ctx := &goproxy.ProxyCtx{User: "alice", UserData: policy}
+
+	_, resp := e.OnRequest(req, ctx)
+	if resp == nil || resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 for host outside allowlist, got %+v", resp)
+	}
+}
+
+func TestPolicyEnforcerRateLimit(t *testing.T) {
+	e := NewPolicyEnforcer()
+	policy := &UserPolicy{RequestsPerSecond: 1, Burst: 1}
+	ctx := &goproxy.ProxyCtx{User: "alice", UserData: policy}
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	if _, resp := e.OnRequest(req, ctx); resp != nil {
+		t.Fatalf("expected first request to pass, got %+v", resp)
+	}
+
+	req2 := httptest.NewRequest("GET", "http://example.com/", nil)
+	_, resp := e.OnRequest(req2, ctx)
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 once burst exhausted, got %+v", resp)
+	}
+}
+
+func TestHostMatchesAny(t *testing.T) {
+	if !hostMatchesAny([]string{"*.example.com"}, "api.example.com") {
+		t.Error("expected api.example.com to match *.example.com")
+	}
+	if hostMatchesAny([]string{"*.example.com"}, "example.com") {
+		t.Error("did not expect bare example.com to match *.example.com")
+	}
+}
+
+func TestHandleConnectDefaultsToMitm(t *testing.T) {
+	e := NewPolicyEnforcer()
+	ctx := &goproxy.ProxyCtx{User: "alice", UserData: &UserPolicy{}}
+
+	action, _ := e.HandleConnect("example.com:443", ctx)
+	if action.Action != goproxy.ConnectMitm {
+		t.Errorf("action = %v, want ConnectMitm when DisableMITM is unset", action.Action)
+	}
+}
+
+func TestHandleConnectDisableMITM(t *testing.T) {
+	e := NewPolicyEnforcer()
+	ctx := &goproxy.ProxyCtx{User: "alice", UserData: &UserPolicy{DisableMITM: true}}
+
+	action, _ := e.HandleConnect("example.com:443", ctx)
+	if action.Action != goproxy.ConnectAccept {
+		t.Errorf("action = %v, want ConnectAccept when DisableMITM is set", action.Action)
+	}
+}
+
+func TestPolicyEnforcerBytesInQuotaExceeded(t *testing.T) {
+	e := NewPolicyEnforcer()
+	policy := &UserPolicy{BytesInQuota: 10}
+	ctx := &goproxy.ProxyCtx{User: "alice", UserData: policy}
+	atomic.AddInt64(&e.usageFor("alice").bytesIn, 11)
+
+	res := &http.Response{Request: httptest.NewRequest("GET", "http://example.com/", nil)}
+	resp := e.OnResponse(res, ctx)
+	if resp == nil || resp.StatusCode != http.StatusInsufficientStorage {
+		t.Fatalf("expected 507 once bytes-in quota is exceeded, got %+v", resp)
+	}
+}
+
+func TestPolicyEnforcerBytesOutQuotaExceeded(t *testing.T) {
+	e := NewPolicyEnforcer()
+	policy := &UserPolicy{BytesOutQuota: 10}
+	ctx := &goproxy.ProxyCtx{User: "alice", UserData: policy}
+	atomic.AddInt64(&e.usageFor("alice").bytesOut, 11)
+
+	res := &http.Response{Request: httptest.NewRequest("GET", "http://example.com/", nil)}
+	resp := e.OnResponse(res, ctx)
+	if resp == nil || resp.StatusCode != http.StatusInsufficientStorage {
+		t.Fatalf("expected 507 once bytes-out quota is exceeded, got %+v", resp)
+	}
+}
+
+func TestHandleConnectMitmDoesNotLeakPendingUpstream(t *testing.T) {
+	e := NewPolicyEnforcer()
+	upstream, err := url.Parse("http://upstream.example:3128")
+	if err != nil {
+		t.Fatalf("parsing upstream URL: %v", err)
+	}
+	req := httptest.NewRequest("CONNECT", "https://example.com:443/", nil)
+	ctx := &goproxy.ProxyCtx{User: "alice", Req: req, UserData: &UserPolicy{Upstream: upstream}}
+
+	action, _ := e.HandleConnect("example.com:443", ctx)
+	if action.Action != goproxy.ConnectMitm {
+		t.Fatalf("action = %v, want ConnectMitm", action.Action)
+	}
+	if len(e.pending) != 0 {
+		t.Errorf("pending = %d entries, want 0: a MITM'd CONNECT never reaches connectDial to clean one up", len(e.pending))
+	}
+}
+
+func TestHandleConnectNoMitmStashesPendingUpstream(t *testing.T) {
+	e := NewPolicyEnforcer()
+	upstream, err := url.Parse("http://upstream.example:3128")
+	if err != nil {
+		t.Fatalf("parsing upstream URL: %v", err)
+	}
+	req := httptest.NewRequest("CONNECT", "https://example.com:443/", nil)
+	ctx := &goproxy.ProxyCtx{User: "alice", Req: req, UserData: &UserPolicy{Upstream: upstream, DisableMITM: true}}
+
+	action, _ := e.HandleConnect("example.com:443", ctx)
+	if action.Action != goproxy.ConnectAccept {
+		t.Fatalf("action = %v, want ConnectAccept", action.Action)
+	}
+	if len(e.pending) != 1 {
+		t.Errorf("pending = %d entries, want 1: connectDial needs it to route this CONNECT through the upstream", len(e.pending))
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	b := newTokenBucket(0.000001, 2)
+	if !b.Allow() {
+		t.Error("expected first call to be allowed")
+	}
+	if !b.Allow() {
+		t.Error("expected second call (within burst) to be allowed")
+	}
+	if b.Allow() {
+		t.Error("expected third call to be rate limited")
+	}
+}
@@ -0,0 +1,28 @@
+// Package httpcache implements an RFC 7234 conformant HTTP cache that can be
+// plugged into a goproxy.ProxyHttpServer via OnRequest/OnResponse handlers.
+package httpcache
+
+import "errors"
+
+// ErrNotFoundInCache is returned by Cache.Retrieve when no resource is stored
+// for the given key.
+var ErrNotFoundInCache = errors.New("httpcache: key not found in cache")
+
+// Cache stores and retrieves cached HTTP resources by key. Implementations
+// must be safe for concurrent use.
+type Cache interface {
+	// Retrieve returns the resource stored under key, or ErrNotFoundInCache
+	// if there is none.
+	Retrieve(key string) (*Resource, error)
+
+	// Store saves resource under every key given. Existing entries for
+	// those keys are replaced.
+	Store(resource *Resource, keys ...string) error
+
+	// Freshen updates the stored headers and TTL for the resource at key
+	// without replacing its body, as happens on a 304 Not Modified.
+	Freshen(resource *Resource, key string) error
+
+	// Purge removes any resource stored under key.
+	Purge(key string) error
+}
@@ -0,0 +1,57 @@
+package httpcache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheControl holds the parsed directives of a Cache-Control header. Values
+// are kept as the raw strings that followed "=", if any, so callers can
+// interpret them as durations, tokens, or presence-only flags.
+type CacheControl map[string][]string
+
+// ParseCacheControl parses every Cache-Control header found in h.
+func ParseCacheControl(h http.Header) (CacheControl, error) {
+	cc := CacheControl{}
+	for _, header := range h["Cache-Control"] {
+		for _, part := range strings.Split(header, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			if i := strings.IndexByte(part, '='); i != -1 {
+				name := strings.ToLower(strings.TrimSpace(part[:i]))
+				value := strings.Trim(strings.TrimSpace(part[i+1:]), `"`)
+				cc[name] = append(cc[name], value)
+			} else {
+				cc[strings.ToLower(part)] = nil
+			}
+		}
+	}
+	return cc, nil
+}
+
+// Has reports whether directive was present, with or without a value.
+func (cc CacheControl) Has(directive string) bool {
+	_, ok := cc[directive]
+	return ok
+}
+
+// Get returns the first value associated with directive, if any.
+func (cc CacheControl) Get(directive string) string {
+	if values := cc[directive]; len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Duration parses directive's value (delta-seconds) as a time.Duration.
+func (cc CacheControl) Duration(directive string) (time.Duration, error) {
+	seconds, err := strconv.ParseInt(cc.Get(directive), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
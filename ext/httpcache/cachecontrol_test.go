@@ -0,0 +1,46 @@
+package httpcache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	h := http.Header{}
+	h.Set("Cache-Control", `max-age=60, no-cache, private="x-foo"`)
+
+	cc, err := ParseCacheControl(h)
+	if err != nil {
+		t.Fatalf("ParseCacheControl: %s", err)
+	}
+
+	if !cc.Has("no-cache") {
+		t.Error("expected no-cache directive to be present")
+	}
+	if !cc.Has("max-age") {
+		t.Error("expected max-age directive to be present")
+	}
+
+	d, err := cc.Duration("max-age")
+	if err != nil {
+		t.Fatalf("Duration(max-age): %s", err)
+	}
+	if d != 60*time.Second {
+		t.Errorf("max-age = %s, want 60s", d)
+	}
+
+	if got := cc.Get("private"); got != "x-foo" {
+		t.Errorf("private = %q, want %q", got, "x-foo")
+	}
+}
+
+func TestCacheControlMissingDirective(t *testing.T) {
+	cc := CacheControl{}
+	if cc.Has("max-age") {
+		t.Error("expected max-age to be absent")
+	}
+	if _, err := cc.Duration("max-age"); err == nil {
+		t.Error("expected error parsing absent max-age")
+	}
+}
@@ -0,0 +1,57 @@
+package httpcache
+
+import "sync"
+
+// RequestCoalescer ensures that concurrent calls for the same key result in
+// a single call to fn; every caller waiting on that key receives its own
+// clone of the result, so each can read the resource's body without racing
+// the others.
+type RequestCoalescer struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	wg       sync.WaitGroup
+	resource *Resource
+	body     []byte
+	err      error
+}
+
+// NewRequestCoalescer returns a ready-to-use RequestCoalescer.
+func NewRequestCoalescer() *RequestCoalescer {
+	return &RequestCoalescer{calls: make(map[string]*coalescedCall)}
+}
+
+// Do runs fn for key unless another goroutine is already doing so, in which
+// case it blocks until that call completes. fn returns the resource's body
+// alongside it so Do can hand every waiter its own clone.
+func (rc *RequestCoalescer) Do(key string, fn func() (*Resource, []byte, error)) (*Resource, error) {
+	rc.mu.Lock()
+	if call, ok := rc.calls[key]; ok {
+		rc.mu.Unlock()
+		call.wg.Wait()
+		return cloneCoalesced(call), call.err
+	}
+
+	call := &coalescedCall{}
+	call.wg.Add(1)
+	rc.calls[key] = call
+	rc.mu.Unlock()
+
+	call.resource, call.body, call.err = fn()
+	call.wg.Done()
+
+	rc.mu.Lock()
+	delete(rc.calls, key)
+	rc.mu.Unlock()
+
+	return cloneCoalesced(call), call.err
+}
+
+func cloneCoalesced(call *coalescedCall) *Resource {
+	if call.resource == nil {
+		return nil
+	}
+	return cloneResource(call.resource, call.body)
+}
@@ -0,0 +1,150 @@
+package httpcache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DiskCache is a Cache backed by a directory on disk. Each entry is stored
+// as two files: a body file and a JSON metadata file, named after the
+// SHA-256 hash of the cache key, following the layout popularized by the
+// apt-proxy getFilenames(url) scheme.
+type DiskCache struct {
+	Dir string
+}
+
+// NewDiskCache returns a DiskCache rooted at dir. The directory is created
+// if it does not already exist.
+func NewDiskCache(dir string) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{Dir: dir}, nil
+}
+
+// diskMetadata is the JSON sidecar written next to each cached body.
+type diskMetadata struct {
+	URL          string      `json:"url"`
+	Method       string      `json:"method"`
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header"`
+	StoredAt     time.Time   `json:"stored_at"`
+	LastModified string      `json:"last_modified,omitempty"`
+}
+
+func (c *DiskCache) filenames(key string) (body, meta string) {
+	sum := sha256.Sum256([]byte(key))
+	name := hex.EncodeToString(sum[:])
+	return filepath.Join(c.Dir, name+".body"), filepath.Join(c.Dir, name+".json")
+}
+
+// Retrieve implements Cache.
+func (c *DiskCache) Retrieve(key string) (*Resource, error) {
+	bodyPath, metaPath := c.filenames(key)
+
+	metaBytes, err := ioutil.ReadFile(metaPath)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFoundInCache
+	} else if err != nil {
+		return nil, err
+	}
+
+	var meta diskMetadata
+	if err := json.Unmarshal(metaBytes, &meta); err != nil {
+		return nil, err
+	}
+
+	body, err := ioutil.ReadFile(bodyPath)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFoundInCache
+	} else if err != nil {
+		return nil, err
+	}
+
+	resource := NewStoredResource(meta.Status, int64(len(body)), ioutil.NopCloser(bytes.NewReader(body)), meta.Header, meta.StoredAt)
+	if u, err := url.Parse(meta.URL); err == nil {
+		resource.SetRequestInfo(meta.Method, u)
+	}
+	return resource, nil
+}
+
+// Store implements Cache.
+func (c *DiskCache) Store(resource *Resource, keys ...string) error {
+	body, err := ioutil.ReadAll(resource)
+	if err != nil {
+		return err
+	}
+	resource.ReadCloser = nil
+
+	meta := diskMetadata{
+		Method:       resource.Method(),
+		Status:       resource.Status(),
+		Header:       resource.Header(),
+		StoredAt:     resource.StoredAt(),
+		LastModified: resource.Header().Get("Last-Modified"),
+	}
+	if u := resource.URL(); u != nil {
+		meta.URL = u.String()
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		bodyPath, metaPath := c.filenames(key)
+		if err := ioutil.WriteFile(bodyPath, body, 0644); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(metaPath, metaBytes, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Freshen implements Cache. It rewrites only the metadata file, leaving the
+// stored body untouched, as required when a 304 response refreshes a cache
+// entry's headers and TTL.
+func (c *DiskCache) Freshen(resource *Resource, key string) error {
+	_, metaPath := c.filenames(key)
+	if _, err := os.Stat(metaPath); os.IsNotExist(err) {
+		return ErrNotFoundInCache
+	}
+
+	meta := diskMetadata{
+		Method:       resource.Method(),
+		Status:       resource.Status(),
+		Header:       resource.Header(),
+		StoredAt:     resource.StoredAt(),
+		LastModified: resource.Header().Get("Last-Modified"),
+	}
+	if u := resource.URL(); u != nil {
+		meta.URL = u.String()
+	}
+	metaBytes, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(metaPath, metaBytes, 0644)
+}
+
+// Purge implements Cache.
+func (c *DiskCache) Purge(key string) error {
+	bodyPath, metaPath := c.filenames(key)
+	if err := os.Remove(bodyPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(metaPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
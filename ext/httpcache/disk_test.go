@@ -0,0 +1,205 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestDiskCache(t *testing.T) *DiskCache {
+	t.Helper()
+	c, err := NewDiskCache(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewDiskCache: %s", err)
+	}
+	return c
+}
+
+func TestDiskCacheStoreRetrieve(t *testing.T) {
+	c := newTestDiskCache(t)
+
+	body := io.NopCloser(strings.NewReader("hello"))
+	header := http.Header{}
+	header.Set("ETag", `"v1"`)
+	resource := NewResource(http.StatusOK, 5, body, header)
+
+	if err := c.Store(resource, "key-a"); err != nil {
+		t.Fatalf("Store: %s", err)
+	}
+
+	got, err := c.Retrieve("key-a")
+	if err != nil {
+		t.Fatalf("Retrieve: %s", err)
+	}
+	data, err := io.ReadAll(got)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("body = %q, want %q", data, "hello")
+	}
+	if got.Header().Get("ETag") != `"v1"` {
+		t.Errorf("ETag = %q, want %q", got.Header().Get("ETag"), `"v1"`)
+	}
+}
+
+// TestDiskCacheRoundTripsMetadata proves the JSON sidecar round-trips every
+// field Retrieve reconstructs a Resource from -- method, URL, status, and
+// stored-at -- not just the body and headers.
+func TestDiskCacheRoundTripsMetadata(t *testing.T) {
+	c := newTestDiskCache(t)
+
+	u, err := url.Parse("http://example.com/path?q=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resource := NewResource(http.StatusPartialContent, 4, io.NopCloser(strings.NewReader("body")), http.Header{})
+	resource.SetRequestInfo("GET", u)
+
+	if err := c.Store(resource, "key"); err != nil {
+		t.Fatalf("Store: %s", err)
+	}
+
+	got, err := c.Retrieve("key")
+	if err != nil {
+		t.Fatalf("Retrieve: %s", err)
+	}
+	if got.Status() != http.StatusPartialContent {
+		t.Errorf("Status() = %d, want %d", got.Status(), http.StatusPartialContent)
+	}
+	if got.Method() != "GET" {
+		t.Errorf("Method() = %q, want GET", got.Method())
+	}
+	if got.URL() == nil || got.URL().String() != u.String() {
+		t.Errorf("URL() = %v, want %v", got.URL(), u)
+	}
+	if got.StoredAt().IsZero() {
+		t.Error("expected a non-zero StoredAt")
+	}
+}
+
+func TestDiskCacheRetrieveMissing(t *testing.T) {
+	c := newTestDiskCache(t)
+
+	if _, err := c.Retrieve("missing"); err != ErrNotFoundInCache {
+		t.Errorf("Retrieve(missing) = %v, want ErrNotFoundInCache", err)
+	}
+}
+
+// TestDiskCacheRetrieveStaleBodyWithoutMeta proves a body file left behind
+// without its metadata sidecar -- e.g. a process killed between the two
+// WriteFile calls in Store -- is treated as a miss rather than Retrieve
+// panicking or returning a Resource with zero-value metadata.
+func TestDiskCacheRetrieveStaleBodyWithoutMeta(t *testing.T) {
+	c := newTestDiskCache(t)
+	bodyPath, _ := c.filenames("orphaned")
+
+	if err := os.WriteFile(bodyPath, []byte("leftover"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Retrieve("orphaned"); err != ErrNotFoundInCache {
+		t.Errorf("Retrieve(orphaned) = %v, want ErrNotFoundInCache", err)
+	}
+}
+
+// TestDiskCacheRetrieveStaleMetaWithoutBody covers the opposite race: a
+// metadata sidecar written (or left behind by a Purge that failed partway)
+// with no body file to back it.
+func TestDiskCacheRetrieveStaleMetaWithoutBody(t *testing.T) {
+	c := newTestDiskCache(t)
+
+	if err := c.Store(NewResource(http.StatusOK, 1, io.NopCloser(strings.NewReader("x")), http.Header{}), "key"); err != nil {
+		t.Fatalf("Store: %s", err)
+	}
+	bodyPath, _ := c.filenames("key")
+	if err := os.Remove(bodyPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Retrieve("key"); err != ErrNotFoundInCache {
+		t.Errorf("Retrieve(key) = %v, want ErrNotFoundInCache", err)
+	}
+}
+
+func TestDiskCacheFreshenKeepsBodyUpdatesMetadata(t *testing.T) {
+	c := newTestDiskCache(t)
+	header := http.Header{"ETag": []string{`"v1"`}, "Cache-Control": []string{"max-age=60"}}
+	if err := c.Store(NewResource(http.StatusOK, 5, io.NopCloser(strings.NewReader("hello")), header), "key"); err != nil {
+		t.Fatalf("Store: %s", err)
+	}
+
+	freshHeader := http.Header{"ETag": []string{`"v1"`}, "Cache-Control": []string{"max-age=120"}}
+	fresh := NewResource(http.StatusOK, 0, nil, freshHeader)
+	if err := c.Freshen(fresh, "key"); err != nil {
+		t.Fatalf("Freshen: %s", err)
+	}
+
+	got, err := c.Retrieve("key")
+	if err != nil {
+		t.Fatalf("Retrieve: %s", err)
+	}
+	data, err := io.ReadAll(got)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("body = %q, want %q (Freshen must not touch the stored body)", data, "hello")
+	}
+	if got.Header().Get("Cache-Control") != "max-age=120" {
+		t.Errorf("Cache-Control = %q, want %q", got.Header().Get("Cache-Control"), "max-age=120")
+	}
+}
+
+func TestDiskCacheFreshenMissing(t *testing.T) {
+	c := newTestDiskCache(t)
+
+	err := c.Freshen(NewResource(http.StatusOK, 0, nil, http.Header{}), "missing")
+	if err != ErrNotFoundInCache {
+		t.Errorf("Freshen(missing) = %v, want ErrNotFoundInCache", err)
+	}
+}
+
+func TestDiskCachePurge(t *testing.T) {
+	c := newTestDiskCache(t)
+	c.Store(NewResource(http.StatusOK, 1, io.NopCloser(strings.NewReader("x")), http.Header{}), "key")
+
+	if err := c.Purge("key"); err != nil {
+		t.Fatalf("Purge: %s", err)
+	}
+	if _, err := c.Retrieve("key"); err != ErrNotFoundInCache {
+		t.Error("expected key to be gone after Purge")
+	}
+
+	bodyPath, metaPath := c.filenames("key")
+	if _, err := os.Stat(bodyPath); !os.IsNotExist(err) {
+		t.Errorf("expected body file %s to be removed", bodyPath)
+	}
+	if _, err := os.Stat(metaPath); !os.IsNotExist(err) {
+		t.Errorf("expected metadata file %s to be removed", metaPath)
+	}
+}
+
+func TestDiskCachePurgeMissingIsNotAnError(t *testing.T) {
+	c := newTestDiskCache(t)
+
+	if err := c.Purge("never-stored"); err != nil {
+		t.Errorf("Purge(never-stored) = %v, want nil", err)
+	}
+}
+
+func TestNewDiskCacheCreatesDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "nested", "cache")
+
+	c, err := NewDiskCache(dir)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %s", err)
+	}
+	if _, err := os.Stat(c.Dir); err != nil {
+		t.Errorf("expected %s to exist: %s", c.Dir, err)
+	}
+}
@@ -0,0 +1,50 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Key identifies a stored resource. Two requests that should be answered
+// with the same cached response produce the same Key.
+type Key struct {
+	method string
+	url    string
+	vary   string
+}
+
+// NewKey builds the primary cache key for a method/URL pair.
+func NewKey(method string, u *url.URL) Key {
+	return Key{method: method, url: u.String()}
+}
+
+// String returns the canonical representation of the key.
+func (k Key) String() string {
+	if k.vary == "" {
+		return k.method + " " + k.url
+	}
+	return k.method + " " + k.url + "::" + k.vary
+}
+
+// ForMethod returns a copy of the key for a different request method, used
+// to look up a GET response when serving a HEAD request.
+func (k Key) ForMethod(method string) Key {
+	k.method = method
+	return k
+}
+
+// Vary returns the secondary key derived from the request header values
+// listed in a response's Vary header, as required by RFC 7234 4.1.
+func (k Key) Vary(varyHeader string, req *http.Request) Key {
+	var parts []string
+	for _, name := range strings.Split(varyHeader, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		parts = append(parts, strings.ToLower(name)+"="+req.Header.Get(name))
+	}
+	k.vary = strings.Join(parts, "&")
+	return k
+}
@@ -0,0 +1,129 @@
+package httpcache
+
+import (
+	"bytes"
+	"container/list"
+	"io/ioutil"
+	"sync"
+)
+
+// MemoryCache is an in-memory Cache bounded by total stored body bytes. When
+// a Store would exceed MaxBytes, the least recently used entries are evicted
+// until it fits.
+type MemoryCache struct {
+	MaxBytes int64
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	curBytes int64
+}
+
+type memoryEntry struct {
+	key      string
+	body     []byte
+	resource *Resource
+}
+
+// NewMemoryCache returns a MemoryCache that holds at most maxBytes of
+// response bodies across all stored keys.
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		MaxBytes: maxBytes,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+// Retrieve implements Cache.
+func (c *MemoryCache) Retrieve(key string) (*Resource, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, ErrNotFoundInCache
+	}
+	c.lru.MoveToFront(elem)
+
+	entry := elem.Value.(*memoryEntry)
+	return cloneResource(entry.resource, entry.body), nil
+}
+
+// Store implements Cache.
+func (c *MemoryCache) Store(resource *Resource, keys ...string) error {
+	body, err := ioutil.ReadAll(resource)
+	if err != nil {
+		return err
+	}
+	resource.ReadCloser = nil
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range keys {
+		c.removeLocked(key)
+
+		entry := &memoryEntry{key: key, body: body, resource: resource}
+		elem := c.lru.PushFront(entry)
+		c.entries[key] = elem
+		c.curBytes += int64(len(body))
+	}
+
+	c.evictLocked()
+	return nil
+}
+
+// Freshen implements Cache.
+func (c *MemoryCache) Freshen(resource *Resource, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return ErrNotFoundInCache
+	}
+	entry := elem.Value.(*memoryEntry)
+	entry.resource = resource
+	c.lru.MoveToFront(elem)
+	return nil
+}
+
+// Purge implements Cache.
+func (c *MemoryCache) Purge(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeLocked(key)
+	return nil
+}
+
+func (c *MemoryCache) removeLocked(key string) {
+	elem, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	entry := elem.Value.(*memoryEntry)
+	c.curBytes -= int64(len(entry.body))
+	c.lru.Remove(elem)
+	delete(c.entries, key)
+}
+
+func (c *MemoryCache) evictLocked() {
+	if c.MaxBytes <= 0 {
+		return
+	}
+	for c.curBytes > c.MaxBytes {
+		oldest := c.lru.Back()
+		if oldest == nil {
+			return
+		}
+		entry := oldest.Value.(*memoryEntry)
+		c.curBytes -= int64(len(entry.body))
+		c.lru.Remove(oldest)
+		delete(c.entries, entry.key)
+	}
+}
+
+func cloneResource(resource *Resource, body []byte) *Resource {
+	return NewStoredResource(resource.Status(), resource.ContentLength(), ioutil.NopCloser(bytes.NewReader(body)), resource.Header(), resource.StoredAt())
+}
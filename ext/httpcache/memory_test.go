@@ -0,0 +1,57 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestMemoryCacheStoreRetrieve(t *testing.T) {
+	c := NewMemoryCache(1 << 20)
+
+	body := io.NopCloser(strings.NewReader("hello"))
+	resource := NewResource(http.StatusOK, 5, body, http.Header{})
+
+	if err := c.Store(resource, "key-a"); err != nil {
+		t.Fatalf("Store: %s", err)
+	}
+
+	got, err := c.Retrieve("key-a")
+	if err != nil {
+		t.Fatalf("Retrieve: %s", err)
+	}
+	data, err := io.ReadAll(got)
+	if err != nil {
+		t.Fatalf("ReadAll: %s", err)
+	}
+	if string(data) != "hello" {
+		t.Errorf("body = %q, want %q", data, "hello")
+	}
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	c := NewMemoryCache(5)
+
+	c.Store(NewResource(http.StatusOK, 5, io.NopCloser(strings.NewReader("aaaaa")), http.Header{}), "a")
+	c.Store(NewResource(http.StatusOK, 5, io.NopCloser(strings.NewReader("bbbbb")), http.Header{}), "b")
+
+	if _, err := c.Retrieve("a"); err != ErrNotFoundInCache {
+		t.Error("expected \"a\" to be evicted once \"b\" pushed the cache over its byte limit")
+	}
+	if _, err := c.Retrieve("b"); err != nil {
+		t.Errorf("Retrieve(b): %s", err)
+	}
+}
+
+func TestMemoryCachePurge(t *testing.T) {
+	c := NewMemoryCache(1 << 20)
+	c.Store(NewResource(http.StatusOK, 1, io.NopCloser(strings.NewReader("x")), http.Header{}), "key")
+
+	if err := c.Purge("key"); err != nil {
+		t.Fatalf("Purge: %s", err)
+	}
+	if _, err := c.Retrieve("key"); err != ErrNotFoundInCache {
+		t.Error("expected key to be gone after Purge")
+	}
+}
@@ -0,0 +1,397 @@
+package httpcache
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/textproto"
+	"time"
+
+	"github.com/elazarl/goproxy"
+)
+
+// Handler wires a Cache into a goproxy.ProxyHttpServer's request/response
+// pipeline, implementing the RFC 7234 cache flow.
+type Handler struct {
+	// Cache is the backend responses are read from and written to.
+	Cache Cache
+
+	// Shared marks the proxy as a shared cache (e.g. serving many users),
+	// which strips private/Set-Cookie headers from stored responses and
+	// honors s-maxage and private Cache-Control directives.
+	Shared bool
+
+	// Transport performs the origin fetch for a cache miss. It defaults to
+	// http.DefaultTransport; OnRequest uses it directly, rather than
+	// letting the miss fall through to goproxy's own RoundTrip, so that
+	// concurrent misses for the same key can be coalesced into one fetch.
+	Transport http.RoundTripper
+
+	coalescer *RequestCoalescer
+	fetches   *RequestCoalescer
+}
+
+// NewHandler returns a Handler backed by cache.
+func NewHandler(cache Cache, shared bool) *Handler {
+	return &Handler{
+		Cache:     cache,
+		Shared:    shared,
+		Transport: http.DefaultTransport,
+		coalescer: NewRequestCoalescer(),
+		fetches:   NewRequestCoalescer(),
+	}
+}
+
+// Attach installs h on proxy's request and response pipelines.
+func (h *Handler) Attach(proxy *goproxy.ProxyHttpServer) {
+	proxy.OnRequest().DoFunc(h.OnRequest)
+	proxy.OnResponse().DoFunc(h.OnResponse)
+}
+
+// OnRequest attempts to serve req from cache, short-circuiting the round
+// trip to the origin when possible.
+func (h *Handler) OnRequest(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+	return h.TryServeCachedResponse(req)
+}
+
+// OnResponse stores res in the cache when it is cacheable, or freshens the
+// existing entry when res is a 304 revalidation result.
+func (h *Handler) OnResponse(res *http.Response, ctx *goproxy.ProxyCtx) *http.Response {
+	if res == nil {
+		return res
+	}
+	return h.TryCacheResponse(res)
+}
+
+// TryServeCachedResponse looks up req in the cache and, if a usable entry is
+// found, returns a response synthesized from it. A nil response means the
+// request should be forwarded to the origin as usual (adding conditional
+// headers for revalidation where applicable).
+func (h *Handler) TryServeCachedResponse(req *http.Request) (*http.Request, *http.Response) {
+	cacheRequest, err := NewCacheRequest(req)
+	if err != nil {
+		return req, goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusInternalServerError, err.Error())
+	}
+
+	if !cacheRequest.IsCacheable() {
+		return req, nil
+	}
+
+	resource, err := h.lookup(cacheRequest)
+	if err == ErrNotFoundInCache {
+		if cacheRequest.CacheControl.Has("only-if-cached") {
+			return req, goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusGatewayTimeout, "key not in cache")
+		}
+		return h.fetchAndCache(cacheRequest)
+	} else if err != nil {
+		return req, goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusInternalServerError, err.Error())
+	}
+
+	if h.needsValidation(resource, cacheRequest) {
+		if cacheRequest.CacheControl.Has("only-if-cached") {
+			return req, goproxy.NewResponse(req, goproxy.ContentTypeText, http.StatusGatewayTimeout, "key not in cache")
+		}
+
+		addRevalidationHeaders(req, resource)
+		return req, nil
+	}
+
+	return req, h.newCachedResponse(cacheRequest, resource)
+}
+
+// TryCacheResponse decides whether to store res, whether to treat it as a
+// freshening 304, and returns the response the client should ultimately
+// see.
+func (h *Handler) TryCacheResponse(res *http.Response) *http.Response {
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusNotModified {
+		return res
+	}
+
+	cacheRequest, err := NewCacheRequest(res.Request)
+	if err != nil {
+		return goproxy.NewResponse(res.Request, goproxy.ContentTypeText, http.StatusInternalServerError, err.Error())
+	}
+
+	if !cacheRequest.IsCacheable() {
+		return res
+	}
+
+	if res.StatusCode == http.StatusNotModified {
+		resource, err := h.lookup(cacheRequest)
+		if err == ErrNotFoundInCache {
+			return res
+		} else if err != nil {
+			return goproxy.NewResponse(res.Request, goproxy.ContentTypeText, http.StatusInternalServerError, err.Error())
+		}
+
+		freshened := freshenHeaders(resource.Header(), res.Header)
+		freshened.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+		resource = NewStoredResource(resource.Status(), resource.ContentLength(), resource, freshened, time.Now())
+		resource.SetRequestInfo(cacheRequest.Method, cacheRequest.URL)
+
+		key := cacheRequest.Key.String()
+		if vary := resource.Header().Get("Vary"); vary != "" {
+			key = cacheRequest.Key.Vary(vary, cacheRequest.Request).String()
+		}
+		if err := h.Cache.Freshen(resource, key); err != nil {
+			log.Printf("httpcache: freshening %s failed: %s", key, err)
+		}
+
+		return h.newCachedResponse(cacheRequest, resource)
+	}
+
+	respCC, err := ParseCacheControl(res.Header)
+	if err != nil {
+		return goproxy.NewResponse(res.Request, goproxy.ContentTypeText, http.StatusInternalServerError, err.Error())
+	}
+	if respCC.Has("no-store") {
+		return res
+	}
+	if !responseCacheableWithAuth(res.Request, respCC) {
+		return res
+	}
+
+	return h.storeAndForward(cacheRequest, res)
+}
+
+func (h *Handler) lookup(cacheRequest *cacheRequest) (*Resource, error) {
+	return h.coalescer.Do(cacheRequest.CoalesceKey(), func() (*Resource, []byte, error) {
+		resource, err := h.Cache.Retrieve(cacheRequest.Key.String())
+		if err == ErrNotFoundInCache && cacheRequest.Method == "HEAD" {
+			resource, err = h.Cache.Retrieve(cacheRequest.Key.ForMethod("GET").String())
+			if err != nil {
+				return nil, nil, err
+			}
+			if !resource.HasExplicitExpiration() {
+				return nil, nil, ErrNotFoundInCache
+			}
+			return drainResource(resource)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if vary := resource.Header().Get("Vary"); vary != "" {
+			resource, err = h.Cache.Retrieve(cacheRequest.Key.Vary(vary, cacheRequest.Request).String())
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		return drainResource(resource)
+	})
+}
+
+// drainResource reads resource's body into memory so the coalescer can hand
+// each waiter its own clone, and closes the original.
+func drainResource(resource *Resource) (*Resource, []byte, error) {
+	body, err := io.ReadAll(resource)
+	resource.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	return resource, body, nil
+}
+
+// fetchAndCache performs the origin round trip for a cache miss directly,
+// coalescing concurrent misses for the same key into a single fetch -- a
+// miss left to fall through to goproxy's own RoundTrip would instead issue
+// one origin request per concurrent caller.
+func (h *Handler) fetchAndCache(cacheRequest *cacheRequest) (*http.Request, *http.Response) {
+	resource, err := h.fetches.Do(cacheRequest.CoalesceKey(), func() (*Resource, []byte, error) {
+		resp, err := h.Transport.RoundTrip(cacheRequest.Request)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		resource := NewResource(resp.StatusCode, int64(len(body)), nil, resp.Header)
+		resource.SetRequestInfo(cacheRequest.Method, cacheRequest.URL)
+		if h.Shared {
+			resource.RemovePrivateHeaders()
+		}
+
+		if resp.StatusCode == http.StatusOK {
+			respCC, ccErr := ParseCacheControl(resp.Header)
+			if ccErr == nil && !respCC.Has("no-store") && responseCacheableWithAuth(cacheRequest.Request, respCC) {
+				keys := []string{cacheRequest.Key.String()}
+				if vary := resp.Header.Get("Vary"); vary != "" {
+					keys = append(keys, cacheRequest.Key.Vary(vary, cacheRequest.Request).String())
+				}
+				if err := h.Cache.Store(resource, keys...); err != nil {
+					log.Printf("httpcache: storing %v failed: %s", keys, err)
+				}
+			}
+		}
+
+		return resource, body, nil
+	})
+	if err != nil {
+		return cacheRequest.Request, goproxy.NewResponse(cacheRequest.Request, goproxy.ContentTypeText, http.StatusBadGateway, err.Error())
+	}
+
+	return cacheRequest.Request, h.newCachedResponse(cacheRequest, resource)
+}
+
+func (h *Handler) storeAndForward(cacheRequest *cacheRequest, res *http.Response) *http.Response {
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return res
+	}
+	res.Body.Close()
+
+	resource := NewResource(res.StatusCode, int64(len(body)), io.NopCloser(bytes.NewReader(body)), res.Header)
+	resource.SetRequestInfo(cacheRequest.Method, cacheRequest.URL)
+	if h.Shared {
+		resource.RemovePrivateHeaders()
+	}
+
+	keys := []string{cacheRequest.Key.String()}
+	if vary := res.Header.Get("Vary"); vary != "" {
+		keys = append(keys, cacheRequest.Key.Vary(vary, cacheRequest.Request).String())
+	}
+
+	if err := h.Cache.Store(resource, keys...); err != nil {
+		log.Printf("httpcache: storing %v failed: %s", keys, err)
+	}
+
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	return res
+}
+
+func (h *Handler) newCachedResponse(cacheRequest *cacheRequest, resource *Resource) *http.Response {
+	age, err := resource.Age()
+	if err != nil {
+		return goproxy.NewResponse(cacheRequest.Request, goproxy.ContentTypeText, http.StatusInternalServerError, "error calculating age: "+err.Error())
+	}
+
+	headers := resource.Header().Clone()
+
+	if age > 24*time.Hour && resource.HeuristicFreshness() > 24*time.Hour {
+		textproto.MIMEHeader(headers).Add("Warning", `113 - "Heuristic Expiration"`)
+	}
+
+	freshness, err := h.freshness(resource, cacheRequest)
+	if err != nil || freshness <= 0 {
+		textproto.MIMEHeader(headers).Add("Warning", `110 - "Response is Stale"`)
+	}
+
+	headers.Set("Age", fmt.Sprintf("%.f", math.Floor(age.Seconds())))
+	headers.Set("Via", resource.Via())
+
+	res := &http.Response{
+		Request:       cacheRequest.Request,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		StatusCode:    resource.Status(),
+		ContentLength: resource.ContentLength(),
+		Header:        headers,
+	}
+
+	if cacheRequest.Method == "HEAD" || res.StatusCode == http.StatusNotModified {
+		res.Body = io.NopCloser(bytes.NewReader(nil))
+	} else {
+		res.Body = resource
+	}
+
+	return res
+}
+
+func (h *Handler) needsValidation(resource *Resource, cacheRequest *cacheRequest) bool {
+	if resource.MustValidate(h.Shared) {
+		return true
+	}
+
+	if cacheRequest.CacheControl.Has("no-cache") {
+		return true
+	}
+
+	freshness, err := h.freshness(resource, cacheRequest)
+	if err != nil {
+		return true
+	}
+
+	if cacheRequest.CacheControl.Has("min-fresh") {
+		minFresh, err := cacheRequest.CacheControl.Duration("min-fresh")
+		if err != nil {
+			return true
+		}
+		if freshness < minFresh {
+			return true
+		}
+	}
+
+	if freshness <= 0 && cacheRequest.CacheControl.Has("max-stale") {
+		if len(cacheRequest.CacheControl["max-stale"]) == 0 {
+			return false
+		}
+		if maxStale, err := cacheRequest.CacheControl.Duration("max-stale"); err == nil && maxStale >= -freshness {
+			return false
+		}
+	}
+
+	return freshness <= 0
+}
+
+// freshness returns the duration a resource will remain fresh for, given
+// the request's own Cache-Control overrides.
+func (h *Handler) freshness(resource *Resource, cacheRequest *cacheRequest) (time.Duration, error) {
+	if resource.IsStale() {
+		return 0, nil
+	}
+
+	maxAge, err := resource.MaxAge(h.Shared)
+	if err != nil {
+		return 0, err
+	}
+
+	if cacheRequest.CacheControl.Has("max-age") {
+		reqMaxAge, err := cacheRequest.CacheControl.Duration("max-age")
+		if err == nil && reqMaxAge < maxAge {
+			maxAge = reqMaxAge
+		}
+	}
+
+	if hFresh := resource.HeuristicFreshness(); hFresh > maxAge {
+		maxAge = hFresh
+	}
+
+	age, err := resource.Age()
+	if err != nil {
+		return 0, err
+	}
+
+	return maxAge - age, nil
+}
+
+// addRevalidationHeaders adds If-None-Match/If-Modified-Since to req so a
+// forwarded request can be cheaply answered with a 304 by the origin.
+func addRevalidationHeaders(req *http.Request, resource *Resource) {
+	if etag := resource.Header().Get("ETag"); etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified := resource.Header().Get("Last-Modified"); lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// freshenHeaders implements the RFC 7234 4.3.4 header-update rules for a
+// 304 response: the stored headers are kept except where the 304 response
+// explicitly overrides them.
+func freshenHeaders(stored, fresh http.Header) http.Header {
+	merged := stored.Clone()
+	for name, values := range fresh {
+		if name == "Content-Length" {
+			continue
+		}
+		merged[name] = values
+	}
+	return merged
+}
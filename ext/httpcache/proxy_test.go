@@ -0,0 +1,252 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestTryCacheResponseHonorsNoStore(t *testing.T) {
+	cache := NewMemoryCache(1 << 20)
+	h := NewHandler(cache, true)
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Request:    req,
+		Header:     http.Header{"Cache-Control": []string{"no-store"}},
+		Body:       io.NopCloser(strings.NewReader("secret")),
+	}
+
+	h.TryCacheResponse(res)
+
+	key := NewKey("GET", req.URL).String()
+	if _, err := cache.Retrieve(key); err != ErrNotFoundInCache {
+		t.Error("expected a no-store response to never be cached")
+	}
+}
+
+func TestTryCacheResponseFreshensVaryKey(t *testing.T) {
+	cache := NewMemoryCache(1 << 20)
+	h := NewHandler(cache, true)
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+
+	cacheRequest, err := NewCacheRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	varyKey := cacheRequest.Key.Vary("Accept-Encoding", req).String()
+
+	storedHeader := http.Header{}
+	storedHeader.Set("Vary", "Accept-Encoding")
+	storedHeader.Set("ETag", `"v1"`)
+	storedHeader.Set("Cache-Control", "max-age=60")
+	stored := NewResource(http.StatusOK, 5, io.NopCloser(strings.NewReader("hello")), storedHeader)
+	if err := cache.Store(stored, cacheRequest.Key.String(), varyKey); err != nil {
+		t.Fatal(err)
+	}
+
+	notModifiedHeader := http.Header{}
+	notModifiedHeader.Set("ETag", `"v1"`)
+	notModified := &http.Response{
+		StatusCode: http.StatusNotModified,
+		Request:    req,
+		Header:     notModifiedHeader,
+		Body:       io.NopCloser(strings.NewReader("")),
+	}
+	h.TryCacheResponse(notModified)
+
+	freshened, err := cache.Retrieve(varyKey)
+	if err != nil {
+		t.Fatalf("expected the vary-suffixed key (the one lookup() actually reads) to be freshened: %s", err)
+	}
+	if freshened.Header().Get("ETag") != `"v1"` {
+		t.Errorf("ETag = %q, want %q", freshened.Header().Get("ETag"), `"v1"`)
+	}
+}
+
+// TestTryCacheResponseDoesNotStoreAuthenticatedResponseWithoutPublic proves
+// an Authorization-bearing request's response is never stored unless the
+// response itself opts in via public/must-revalidate/s-maxage -- the
+// request's own Cache-Control is not consulted, since a forward proxy's
+// client controls its own request headers and could otherwise claim
+// "public" on the request to defeat the restriction and have a shared cache
+// later serve its authenticated response to a different user.
+func TestTryCacheResponseDoesNotStoreAuthenticatedResponseWithoutPublic(t *testing.T) {
+	cache := NewMemoryCache(1 << 20)
+	h := NewHandler(cache, true)
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Authorization", "Bearer secret-token")
+	req.Header.Set("Cache-Control", "public")
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Request:    req,
+		Header:     http.Header{"Cache-Control": []string{"max-age=60"}},
+		Body:       io.NopCloser(strings.NewReader("private data")),
+	}
+
+	h.TryCacheResponse(res)
+
+	key := NewKey("GET", req.URL).String()
+	if _, err := cache.Retrieve(key); err != ErrNotFoundInCache {
+		t.Error("expected an authenticated request's response to not be stored absent public/must-revalidate/s-maxage on the response itself")
+	}
+}
+
+// TestTryCacheResponseStoresAuthenticatedResponseWithPublic proves the
+// response's own Cache-Control -- not the request's -- is what allows an
+// authenticated request's response to be stored.
+func TestTryCacheResponseStoresAuthenticatedResponseWithPublic(t *testing.T) {
+	cache := NewMemoryCache(1 << 20)
+	h := NewHandler(cache, true)
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	req.Header.Set("Authorization", "Bearer secret-token")
+	res := &http.Response{
+		StatusCode: http.StatusOK,
+		Request:    req,
+		Header:     http.Header{"Cache-Control": []string{"public, max-age=60"}},
+		Body:       io.NopCloser(strings.NewReader("shared data")),
+	}
+
+	h.TryCacheResponse(res)
+
+	key := NewKey("GET", req.URL).String()
+	if _, err := cache.Retrieve(key); err != nil {
+		t.Errorf("expected a response with Cache-Control: public to be stored even for an authenticated request: %s", err)
+	}
+}
+
+// TestTryServeCachedResponseHonorsRequestNoCache proves a request's own
+// Cache-Control: no-cache (RFC 7234 5.2.1.4) forces revalidation even when
+// the stored resource is otherwise still fresh, rather than the directive
+// being parsed and then silently ignored.
+func TestTryServeCachedResponseHonorsRequestNoCache(t *testing.T) {
+	cache := NewMemoryCache(1 << 20)
+	h := NewHandler(cache, true)
+
+	req := httptest.NewRequest("GET", "http://example.com/", nil)
+	key := NewKey("GET", req.URL).String()
+
+	header := http.Header{}
+	header.Set("Cache-Control", "max-age=60")
+	header.Set("ETag", `"v1"`)
+	stored := NewResource(http.StatusOK, 5, io.NopCloser(strings.NewReader("hello")), header)
+	if err := cache.Store(stored, key); err != nil {
+		t.Fatal(err)
+	}
+
+	req.Header.Set("Cache-Control", "no-cache")
+	outReq, resp := h.TryServeCachedResponse(req)
+	if resp != nil {
+		t.Fatalf("expected no-cache to force revalidation (nil response), got a cached response")
+	}
+	if outReq.Header.Get("If-None-Match") != `"v1"` {
+		t.Errorf("If-None-Match = %q, want %q", outReq.Header.Get("If-None-Match"), `"v1"`)
+	}
+}
+
+func TestFetchAndCacheCoalescesConcurrentMisses(t *testing.T) {
+	var hits int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&hits, 1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("origin response"))
+	}))
+	defer origin.Close()
+
+	cache := NewMemoryCache(1 << 20)
+	h := NewHandler(cache, true)
+
+	const concurrency = 8
+	var wg sync.WaitGroup
+	results := make([]*http.Response, concurrency)
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req, err := http.NewRequest("GET", origin.URL+"/", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			_, resp := h.TryServeCachedResponse(req)
+			results[i] = resp
+		}(i)
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt64(&hits); got != 1 {
+		t.Errorf("origin hits = %d, want 1 (concurrent misses should coalesce into a single fetch)", got)
+	}
+
+	for i, resp := range results {
+		if resp == nil {
+			t.Fatalf("result %d: expected a synthesized response, got nil", i)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("result %d: ReadAll: %s", i, err)
+		}
+		if string(body) != "origin response" {
+			t.Errorf("result %d: body = %q, want %q", i, body, "origin response")
+		}
+	}
+}
+
+// TestFetchAndCacheDoesNotCoalesceAcrossDifferingVary proves two concurrent
+// misses for the same URL but different Accept-Encoding -- a header the
+// origin's Vary names -- each get the origin response for their own
+// Accept-Encoding, rather than one caller's fetch being silently handed to
+// the other regardless of its request headers.
+func TestFetchAndCacheDoesNotCoalesceAcrossDifferingVary(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Encoding")
+		w.Write([]byte("variant:" + r.Header.Get("Accept-Encoding")))
+	}))
+	defer origin.Close()
+
+	cache := NewMemoryCache(1 << 20)
+	h := NewHandler(cache, true)
+
+	var wg sync.WaitGroup
+	results := make([]*http.Response, 2)
+	encodings := []string{"gzip", "identity"}
+	for i, encoding := range encodings {
+		wg.Add(1)
+		go func(i int, encoding string) {
+			defer wg.Done()
+			req, err := http.NewRequest("GET", origin.URL+"/", nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			req.Header.Set("Accept-Encoding", encoding)
+			_, resp := h.TryServeCachedResponse(req)
+			results[i] = resp
+		}(i, encoding)
+	}
+	wg.Wait()
+
+	for i, resp := range results {
+		if resp == nil {
+			t.Fatalf("result %d: expected a synthesized response, got nil", i)
+		}
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("result %d: ReadAll: %s", i, err)
+		}
+		want := "variant:" + encodings[i]
+		if string(body) != want {
+			t.Errorf("result %d: body = %q, want %q (coalesced with the other Accept-Encoding's fetch)", i, body, want)
+		}
+	}
+}
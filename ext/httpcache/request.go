@@ -0,0 +1,84 @@
+package httpcache
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// cacheRequest wraps an inbound request with the pieces of it that the
+// cache subsystem consults repeatedly.
+type cacheRequest struct {
+	*http.Request
+
+	Key          Key
+	CacheControl CacheControl
+}
+
+// NewCacheRequest parses req into a cacheRequest, ready for IsCacheable and
+// for keying lookups into a Cache.
+func NewCacheRequest(req *http.Request) (*cacheRequest, error) {
+	cc, err := ParseCacheControl(req.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cacheRequest{
+		Request:      req,
+		Key:          NewKey(req.Method, req.URL),
+		CacheControl: cc,
+	}, nil
+}
+
+// IsCacheable reports whether req may be served from, or stored in, the
+// cache per RFC 7234 3. Whether an authenticated request's response may be
+// stored depends on the response's own Cache-Control, not the request's --
+// see responseCacheableWithAuth -- since a forward proxy's client controls
+// its own request headers and could otherwise defeat that restriction by
+// simply claiming "public" on the request.
+func (c *cacheRequest) IsCacheable() bool {
+	if c.Method != "GET" && c.Method != "HEAD" {
+		return false
+	}
+	if c.CacheControl.Has("no-store") {
+		return false
+	}
+	return true
+}
+
+// responseCacheableWithAuth reports whether a response to an
+// Authorization-bearing request may be stored, per RFC 7234 3: only if the
+// response's own Cache-Control explicitly allows it via public,
+// must-revalidate, or s-maxage. Requests without an Authorization header are
+// unaffected by this rule.
+func responseCacheableWithAuth(req *http.Request, respCC CacheControl) bool {
+	if req.Header.Get("Authorization") == "" {
+		return true
+	}
+	return respCC.Has("public") || respCC.Has("must-revalidate") || respCC.Has("s-maxage")
+}
+
+// CoalesceKey scopes a RequestCoalescer dedup key to requests that match in
+// every header, not just the primary cache key: Vary isn't known until a
+// stored or freshly fetched response's headers are read, so two concurrent
+// requests for the same URL but different Vary-relevant header values (e.g.
+// Accept-Encoding) could otherwise be coalesced into one lookup/fetch and
+// the second caller would wrongly receive a clone of whichever variant the
+// first caller resolved.
+func (c *cacheRequest) CoalesceKey() string {
+	names := make([]string, 0, len(c.Header))
+	for name := range c.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString(c.Key.String())
+	for _, name := range names {
+		b.WriteString("::")
+		b.WriteString(strings.ToLower(name))
+		b.WriteByte('=')
+		b.WriteString(strings.Join(c.Header.Values(name), ","))
+	}
+	return b.String()
+}
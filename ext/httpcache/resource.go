@@ -0,0 +1,202 @@
+package httpcache
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// hopByHopHeaders are stripped from responses before they are served out of
+// a shared cache, see RFC 7230 6.1.
+var hopByHopHeaders = []string{
+	"Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"TE", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// Resource is a cached response: its body plus the metadata needed to judge
+// freshness and to replay it as an *http.Response.
+type Resource struct {
+	io.ReadCloser
+
+	statusCode    int
+	contentLength int64
+	header        http.Header
+	storedAt      time.Time
+	method        string
+	url           *url.URL
+}
+
+// NewResource wraps a response body and headers for storage. storedAt is
+// recorded as time.Now(); callers restoring a Resource from a backend should
+// use NewStoredResource instead so the original storage time is preserved.
+func NewResource(statusCode int, contentLength int64, body io.ReadCloser, header http.Header) *Resource {
+	return NewStoredResource(statusCode, contentLength, body, header, time.Now())
+}
+
+// NewStoredResource is like NewResource but lets the caller supply the time
+// the resource was originally stored, as read back from a cache backend.
+func NewStoredResource(statusCode int, contentLength int64, body io.ReadCloser, header http.Header, storedAt time.Time) *Resource {
+	if body == nil {
+		body = ioutil.NopCloser(bytes.NewReader(nil))
+	}
+	return &Resource{
+		ReadCloser:    body,
+		statusCode:    statusCode,
+		contentLength: contentLength,
+		header:        header.Clone(),
+		storedAt:      storedAt,
+	}
+}
+
+// Status returns the cached response's status code.
+func (r *Resource) Status() int { return r.statusCode }
+
+// ContentLength returns the cached response's content length, or -1 if
+// unknown.
+func (r *Resource) ContentLength() int64 { return r.contentLength }
+
+// Header returns the cached response's headers.
+func (r *Resource) Header() http.Header { return r.header }
+
+// SetRequestInfo records the method and URL the resource was fetched for,
+// so backends such as DiskCache can include them in stored metadata.
+func (r *Resource) SetRequestInfo(method string, u *url.URL) {
+	r.method = method
+	r.url = u
+}
+
+// Method returns the request method the resource was fetched with, if set
+// via SetRequestInfo.
+func (r *Resource) Method() string { return r.method }
+
+// URL returns the request URL the resource was fetched for, if set via
+// SetRequestInfo.
+func (r *Resource) URL() *url.URL { return r.url }
+
+// StoredAt returns when the resource was written into the cache.
+func (r *Resource) StoredAt() time.Time { return r.storedAt }
+
+// Via returns the value goproxy should set on the Via response header when
+// serving this resource from cache.
+func (r *Resource) Via() string { return "1.1 goproxy (httpcache)" }
+
+// RemovePrivateHeaders strips headers that must not be relayed by a shared
+// cache, such as Set-Cookie, along with hop-by-hop headers.
+func (r *Resource) RemovePrivateHeaders() {
+	r.header.Del("Set-Cookie")
+	r.header.Del("Set-Cookie2")
+	for _, h := range hopByHopHeaders {
+		r.header.Del(h)
+	}
+}
+
+// Age returns how long ago this resource was last validated with the
+// origin, per RFC 7234 4.2.3.
+func (r *Resource) Age() (time.Duration, error) {
+	apparentAge := time.Duration(0)
+	if dateHeader := r.header.Get("Date"); dateHeader != "" {
+		date, err := http.ParseTime(dateHeader)
+		if err != nil {
+			return 0, err
+		}
+		if age := r.storedAt.Sub(date); age > 0 {
+			apparentAge = age
+		}
+	}
+
+	correctedAge := apparentAge
+	if ageHeader := r.header.Get("Age"); ageHeader != "" {
+		if sent, err := parseDeltaSeconds(ageHeader); err == nil && sent > apparentAge {
+			correctedAge = sent
+		}
+	}
+
+	residentTime := time.Since(r.storedAt)
+	return correctedAge + residentTime, nil
+}
+
+// HasExplicitExpiration reports whether the resource carries max-age,
+// s-maxage, or Expires, i.e. whether it opted out of heuristic freshness.
+func (r *Resource) HasExplicitExpiration() bool {
+	cc, _ := ParseCacheControl(r.header)
+	if cc.Has("max-age") || cc.Has("s-maxage") {
+		return true
+	}
+	return r.header.Get("Expires") != ""
+}
+
+// HeuristicFreshness implements the heuristic in RFC 7234 4.2.2: 10% of the
+// time since Last-Modified, for responses without explicit expiration.
+func (r *Resource) HeuristicFreshness() time.Duration {
+	if r.HasExplicitExpiration() {
+		return 0
+	}
+	lastModified, err := http.ParseTime(r.header.Get("Last-Modified"))
+	if err != nil {
+		return 0
+	}
+	if since := r.storedAt.Sub(lastModified); since > 0 {
+		return since / 10
+	}
+	return 0
+}
+
+// MaxAge returns the resource's freshness lifetime, preferring s-maxage for
+// shared caches, then max-age, then Expires.
+func (r *Resource) MaxAge(shared bool) (time.Duration, error) {
+	cc, err := ParseCacheControl(r.header)
+	if err != nil {
+		return 0, err
+	}
+
+	if shared && cc.Has("s-maxage") {
+		return cc.Duration("s-maxage")
+	}
+	if cc.Has("max-age") {
+		return cc.Duration("max-age")
+	}
+
+	if expires := r.header.Get("Expires"); expires != "" {
+		expiresAt, err := http.ParseTime(expires)
+		if err != nil {
+			return 0, nil
+		}
+		date, err := http.ParseTime(r.header.Get("Date"))
+		if err != nil {
+			date = r.storedAt
+		}
+		if d := expiresAt.Sub(date); d > 0 {
+			return d, nil
+		}
+		return 0, nil
+	}
+
+	return 0, nil
+}
+
+// MustValidate reports whether the resource may not be served without
+// revalidation: no-cache always forces it, private forces it for shared
+// caches holding a response meant for a single user.
+func (r *Resource) MustValidate(shared bool) bool {
+	cc, _ := ParseCacheControl(r.header)
+	if cc.Has("no-cache") {
+		return true
+	}
+	return shared && cc.Has("private")
+}
+
+// IsStale reports whether the origin has marked the resource as no longer
+// usable at all, regardless of freshness lifetime (must-revalidate with an
+// expired age is handled by the freshness calculation, not here).
+func (r *Resource) IsStale() bool {
+	cc, _ := ParseCacheControl(r.header)
+	return cc.Has("no-store")
+}
+
+func parseDeltaSeconds(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s + "s")
+	return d, err
+}
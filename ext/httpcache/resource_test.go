@@ -0,0 +1,85 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newTestResource(t *testing.T, header http.Header, storedAt time.Time) *Resource {
+	t.Helper()
+	return NewStoredResource(http.StatusOK, 0, io.NopCloser(nil), header, storedAt)
+}
+
+func TestResourceMaxAge(t *testing.T) {
+	header := http.Header{}
+	header.Set("Cache-Control", "max-age=120")
+	r := newTestResource(t, header, time.Now())
+
+	maxAge, err := r.MaxAge(false)
+	if err != nil {
+		t.Fatalf("MaxAge: %s", err)
+	}
+	if maxAge != 120*time.Second {
+		t.Errorf("MaxAge = %s, want 120s", maxAge)
+	}
+}
+
+func TestResourceMaxAgeSharedPrefersSMaxAge(t *testing.T) {
+	header := http.Header{}
+	header.Set("Cache-Control", "max-age=120, s-maxage=30")
+	r := newTestResource(t, header, time.Now())
+
+	maxAge, err := r.MaxAge(true)
+	if err != nil {
+		t.Fatalf("MaxAge: %s", err)
+	}
+	if maxAge != 30*time.Second {
+		t.Errorf("MaxAge(shared) = %s, want 30s", maxAge)
+	}
+}
+
+func TestResourceAge(t *testing.T) {
+	storedAt := time.Now().Add(-10 * time.Second)
+	header := http.Header{}
+	header.Set("Date", storedAt.UTC().Format(http.TimeFormat))
+	r := newTestResource(t, header, storedAt)
+
+	age, err := r.Age()
+	if err != nil {
+		t.Fatalf("Age: %s", err)
+	}
+	if age < 10*time.Second || age > 11*time.Second {
+		t.Errorf("Age = %s, want ~10s", age)
+	}
+}
+
+func TestResourceMustValidate(t *testing.T) {
+	header := http.Header{}
+	header.Set("Cache-Control", "private")
+	r := newTestResource(t, header, time.Now())
+
+	if r.MustValidate(false) {
+		t.Error("private response should not require validation for a private cache")
+	}
+	if !r.MustValidate(true) {
+		t.Error("private response should require validation for a shared cache")
+	}
+}
+
+func TestResourceRemovePrivateHeaders(t *testing.T) {
+	header := http.Header{}
+	header.Set("Set-Cookie", "session=abc")
+	header.Set("Content-Type", "text/plain")
+	r := newTestResource(t, header, time.Now())
+
+	r.RemovePrivateHeaders()
+
+	if r.Header().Get("Set-Cookie") != "" {
+		t.Error("expected Set-Cookie to be removed")
+	}
+	if r.Header().Get("Content-Type") != "text/plain" {
+		t.Error("expected Content-Type to be preserved")
+	}
+}
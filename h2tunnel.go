@@ -0,0 +1,236 @@
+package goproxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// TunnelWriter is the raw duplex stream a CONNECT tunnel is relayed over.
+// connResponseWriter implements it over a hijacked net.Conn for HTTP/1.1;
+// h2TunnelWriter implements it over a single HTTP/2 stream, for clients
+// that send an RFC 8441 extended CONNECT (a CONNECT request carrying a
+// ":protocol" pseudo-header) instead of negotiating a raw TCP hijack.
+type TunnelWriter interface {
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+	CloseWrite() error
+}
+
+// h2TunnelWriter relays a CONNECT tunnel over a single HTTP/2 stream: reads
+// come from the request body, writes go to the response body, and both
+// stay open for the lifetime of the stream since the handler enabled full
+// duplex mode.
+type h2TunnelWriter struct {
+	w http.ResponseWriter
+	r *http.Request
+}
+
+// newH2TunnelWriter adapts w/r into a TunnelWriter for an HTTP/2 extended
+// CONNECT request. It requires Go's http.ResponseController.EnableFullDuplex
+// support, since otherwise the server buffers the full request body before
+// the handler's writes are allowed to reach the client.
+func newH2TunnelWriter(w http.ResponseWriter, r *http.Request) (TunnelWriter, error) {
+	if r.ProtoMajor != 2 {
+		return nil, errors.New("proxy: h2 tunnel requires an HTTP/2 request")
+	}
+
+	if err := http.NewResponseController(w).EnableFullDuplex(); err != nil {
+		return nil, err
+	}
+	w.WriteHeader(http.StatusOK)
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return &h2TunnelWriter{w: w, r: r}, nil
+}
+
+func (t *h2TunnelWriter) Read(p []byte) (int, error) {
+	return t.r.Body.Read(p)
+}
+
+func (t *h2TunnelWriter) Write(p []byte) (int, error) {
+	n, err := t.w.Write(p)
+	if err == nil {
+		if f, ok := t.w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	return n, err
+}
+
+// CloseWrite closes the request body, which ends this side's reads from the
+// stream. It does not end the response stream: the peer sees end-of-stream
+// only once the handler returns.
+func (t *h2TunnelWriter) CloseWrite() error {
+	return t.r.Body.Close()
+}
+
+// isExtendedConnect reports whether r is an RFC 8441 extended CONNECT --
+// an HTTP/2 CONNECT request carrying a ":protocol" pseudo-header -- which
+// Go's net/http server surfaces as r.Proto == "HTTP/2.0" with a non-empty
+// r.URL (ordinary CONNECT requests leave the request-target as authority
+// form and r.URL empty).
+func isExtendedConnect(r *http.Request) bool {
+	return r.Method == http.MethodConnect && r.ProtoMajor == 2 && r.URL != nil && r.URL.Path != ""
+}
+
+// TunnelFor returns the appropriate TunnelWriter for r: an HTTP/2 stream
+// for an extended CONNECT, or a hijacked net.Conn otherwise. It is the
+// intended replacement for the raw w.(http.Hijacker).Hijack() call at the
+// top of ProxyHttpServer.handleHttps, so that handler can relay tunneled
+// bytes through a single TunnelWriter regardless of protocol version.
+func TunnelFor(w http.ResponseWriter, r *http.Request) (TunnelWriter, error) {
+	if isExtendedConnect(r) {
+		return newH2TunnelWriter(w, r)
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, errors.New("proxy: ResponseWriter does not support hijacking")
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	return NewConnResponseWriter(conn), nil
+}
+
+// EnableH2C wraps proxy so the returned http.Handler also accepts plaintext
+// HTTP/2 (h2c) connections -- i.e. without a TLS ALPN negotiation -- useful
+// for gRPC-over-proxy deployments. Pass the result as an http.Server's
+// Handler instead of proxy itself, e.g.:
+//
+//	server := &http.Server{Addr: addr, Handler: proxy.EnableH2C()}
+//
+// Ordinary CONNECT and non-CONNECT requests are handled by proxy exactly as
+// before. An RFC 8441 extended CONNECT, though, can't go through proxy's own
+// handleHttps: that method is defined in this package's https.go, which this
+// series doesn't touch, and it hijacks the connection as a raw net.Conn
+// before it ever looks at the request -- incompatible with a tunnel that has
+// to stay multiplexed inside a single HTTP/2 stream. So EnableH2C intercepts
+// extended CONNECT requests itself, ahead of proxy.ServeHTTP, and relays them
+// with TunnelFor/TunnelWriter instead -- but it still runs the request
+// through proxy.httpsHandlers first, the same chain OnRequest().HandleConnect
+// registers Basic/Digest/NTLM/Cert auth and policy checks onto for ordinary
+// CONNECTs, so an extended CONNECT can't bypass whatever those require.
+func (proxy *ProxyHttpServer) EnableH2C() http.Handler {
+	h2cHandler := h2c.NewHandler(proxy, &http2.Server{})
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isExtendedConnect(r) {
+			proxy.tunnelExtendedConnect(w, r)
+			return
+		}
+		h2cHandler.ServeHTTP(w, r)
+	})
+}
+
+// tunnelExtendedConnect serves an RFC 8441 extended CONNECT. It first runs
+// r through proxy.httpsHandlers exactly the way proxy.handleHttps does for
+// an ordinary CONNECT -- same default of OkConnect, same first-non-nil-wins
+// loop -- so auth schemes and policy checks registered via
+// OnRequest().HandleConnect(...) are applied before anything is dialed.
+// Only ConnectAccept is actually tunneled here: ConnectMitm/ConnectHTTPMitm
+// need a raw net.Conn to terminate TLS or speak plain HTTP over, which an
+// HTTP/2 stream multiplexed inside a single h2c connection can't offer, so
+// those (and any hijacking action, since h2c ResponseWriters don't support
+// http.Hijacker) are reported back as an error rather than silently
+// downgraded to an unauthenticated passthrough.
+func (proxy *ProxyHttpServer) tunnelExtendedConnect(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	ctx := &ProxyCtx{Req: r, Proxy: proxy}
+	todo := OkConnect
+	for _, h := range proxy.httpsHandlers {
+		newtodo, newhost := h.HandleConnect(host, ctx)
+		if newtodo != nil {
+			todo, host = newtodo, newhost
+			break
+		}
+	}
+
+	switch todo.Action {
+	case ConnectAccept:
+		// handled below
+	case ConnectReject:
+		resp := ctx.Resp
+		if resp == nil {
+			resp = NewResponse(r, ContentTypeText, http.StatusForbidden, "proxy: CONNECT rejected")
+		}
+		for key, values := range resp.Header {
+			for _, v := range values {
+				w.Header().Add(key, v)
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+		if resp.Body != nil {
+			io.Copy(w, resp.Body)
+			resp.Body.Close()
+		}
+		return
+	default:
+		http.Error(w, "proxy: CONNECT action not supported over an h2c tunnel", http.StatusBadGateway)
+		return
+	}
+
+	upstream, err := proxy.dialUpstream(r, host)
+	if err != nil {
+		http.Error(w, "proxy: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	tunnel, err := TunnelFor(w, r)
+	if err != nil {
+		http.Error(w, "proxy: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	relayTunnel(tunnel, upstream)
+}
+
+// dialUpstream opens the upstream connection for a CONNECT-style tunnel,
+// preferring proxy.ConnectDialWithReq, then proxy.ConnectDial, then a plain
+// net.Dial -- the same fallback order proxy.handleHttps uses for an
+// ordinary CONNECT.
+func (proxy *ProxyHttpServer) dialUpstream(r *http.Request, addr string) (net.Conn, error) {
+	if proxy.ConnectDialWithReq != nil {
+		return proxy.ConnectDialWithReq(r, "tcp", addr)
+	}
+	if proxy.ConnectDial != nil {
+		return proxy.ConnectDial("tcp", addr)
+	}
+	return net.Dial("tcp", addr)
+}
+
+// relayTunnel copies bytes in both directions between tunnel and upstream
+// until one side is done, then half-closes the other so neither end blocks
+// waiting for data that will never arrive.
+func relayTunnel(tunnel TunnelWriter, upstream net.Conn) {
+	done := make(chan struct{}, 2)
+
+	go func() {
+		io.Copy(upstream, tunnel)
+		if cw, ok := upstream.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		}
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(tunnel, upstream)
+		tunnel.CloseWrite()
+		done <- struct{}{}
+	}()
+
+	<-done
+	<-done
+}
@@ -0,0 +1,211 @@
+package goproxy
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2"
+)
+
+// TestEnableH2CServesRequestsOverH2C drives a real plaintext HTTP/2 request
+// through the handler EnableH2C returns, proving it's actually wired into a
+// live server rather than sitting next to ServeHTTP unused.
+func TestEnableH2CServesRequestsOverH2C(t *testing.T) {
+	proxy := NewProxyHttpServer()
+	proxy.NonproxyHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("proto=" + r.Proto))
+	})
+
+	srv := httptest.NewServer(proxy.EnableH2C())
+	defer srv.Close()
+
+	client := &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		},
+	}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("GET over h2c: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	if string(body) != "proto=HTTP/2.0" {
+		t.Errorf("body = %q, want the request to have been served as HTTP/2.0", body)
+	}
+}
+
+func TestRelayTunnelCopiesBothDirections(t *testing.T) {
+	clientSide, tunnelSide := net.Pipe()
+	upstreamSide, appSide := net.Pipe()
+
+	tunnel := NewConnResponseWriter(tunnelSide)
+
+	go relayTunnel(tunnel, upstreamSide)
+
+	go clientSide.Write([]byte("ping"))
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(appSide, buf); err != nil {
+		t.Fatalf("reading relayed bytes: %v", err)
+	}
+	if string(buf) != "ping" {
+		t.Errorf("relayed %q, want %q", buf, "ping")
+	}
+
+	go appSide.Write([]byte("pong"))
+
+	buf2 := make([]byte, 4)
+	if _, err := io.ReadFull(clientSide, buf2); err != nil {
+		t.Fatalf("reading reverse-direction bytes: %v", err)
+	}
+	if string(buf2) != "pong" {
+		t.Errorf("relayed %q, want %q", buf2, "pong")
+	}
+
+	clientSide.Close()
+	appSide.Close()
+}
+
+func TestDialUpstreamPrefersConnectDialWithReq(t *testing.T) {
+	proxy := NewProxyHttpServer()
+
+	var usedWithReq, usedPlain bool
+	proxy.ConnectDialWithReq = func(req *http.Request, network, addr string) (net.Conn, error) {
+		usedWithReq = true
+		c1, c2 := net.Pipe()
+		c2.Close()
+		return c1, nil
+	}
+	proxy.ConnectDial = func(network, addr string) (net.Conn, error) {
+		usedPlain = true
+		c1, c2 := net.Pipe()
+		c2.Close()
+		return c1, nil
+	}
+
+	req := httptest.NewRequest(http.MethodConnect, "https://example.com/", nil)
+	conn, err := proxy.dialUpstream(req, "example.com:443")
+	if err != nil {
+		t.Fatalf("dialUpstream: %v", err)
+	}
+	conn.Close()
+
+	if !usedWithReq {
+		t.Error("expected ConnectDialWithReq to be used when set")
+	}
+	if usedPlain {
+		t.Error("did not expect ConnectDial to be used when ConnectDialWithReq is set")
+	}
+}
+
+func TestIsExtendedConnect(t *testing.T) {
+	ordinary := &http.Request{Method: http.MethodConnect, ProtoMajor: 2, URL: &url.URL{}}
+	if isExtendedConnect(ordinary) {
+		t.Error("an ordinary CONNECT (empty URL.Path) should not be treated as extended")
+	}
+
+	extended := &http.Request{Method: http.MethodConnect, ProtoMajor: 2, URL: &url.URL{Path: "/"}}
+	if !isExtendedConnect(extended) {
+		t.Error("an HTTP/2 CONNECT with a non-empty URL.Path should be treated as extended")
+	}
+}
+
+// TestTunnelExtendedConnectHonorsHttpsHandlerReject proves an extended
+// CONNECT can't bypass a registered HandleConnect handler: a handler that
+// rejects the host must stop the request before anything is dialed.
+func TestTunnelExtendedConnectHonorsHttpsHandlerReject(t *testing.T) {
+	proxy := NewProxyHttpServer()
+	proxy.OnRequest().HandleConnect(FuncHttpsHandler(func(host string, ctx *ProxyCtx) (*ConnectAction, string) {
+		return RejectConnect, host
+	}))
+	proxy.ConnectDial = func(network, addr string) (net.Conn, error) {
+		t.Fatal("dialUpstream should not run once a handler has rejected the CONNECT")
+		return nil, nil
+	}
+
+	req := httptest.NewRequest(http.MethodConnect, "/", nil)
+	req.ProtoMajor = 2
+	req.Host = "example.com:443"
+	w := httptest.NewRecorder()
+
+	proxy.tunnelExtendedConnect(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+// TestTunnelExtendedConnectRejectCarriesHeaderAndBody proves a rejecting
+// handler's ctx.Resp isn't reduced to a bare status code over an extended
+// CONNECT: a Proxy-Authenticate challenge and the response body both need to
+// reach the client for schemes like Basic/Digest/Cert to keep working.
+func TestTunnelExtendedConnectRejectCarriesHeaderAndBody(t *testing.T) {
+	proxy := NewProxyHttpServer()
+	proxy.OnRequest().HandleConnect(FuncHttpsHandler(func(host string, ctx *ProxyCtx) (*ConnectAction, string) {
+		ctx.Resp = &http.Response{
+			StatusCode: http.StatusProxyAuthRequired,
+			Header:     http.Header{"Proxy-Authenticate": []string{"Basic realm=proxy"}},
+			Body:       io.NopCloser(strings.NewReader("auth required")),
+		}
+		return RejectConnect, host
+	}))
+
+	req := httptest.NewRequest(http.MethodConnect, "/", nil)
+	req.ProtoMajor = 2
+	req.Host = "example.com:443"
+	w := httptest.NewRecorder()
+
+	proxy.tunnelExtendedConnect(w, req)
+
+	if w.Code != http.StatusProxyAuthRequired {
+		t.Fatalf("status = %d, want %d", w.Code, http.StatusProxyAuthRequired)
+	}
+	if got := w.Header().Get("Proxy-Authenticate"); got != "Basic realm=proxy" {
+		t.Errorf("Proxy-Authenticate = %q, want %q", got, "Basic realm=proxy")
+	}
+	if got := w.Body.String(); got != "auth required" {
+		t.Errorf("body = %q, want %q", got, "auth required")
+	}
+}
+
+// TestTunnelExtendedConnectRejectsMitmAction proves a handler asking for
+// MITM -- which needs a raw net.Conn this h2c tunnel can't hand it -- is
+// reported as an error instead of silently falling through to an
+// unauthenticated passthrough.
+func TestTunnelExtendedConnectRejectsMitmAction(t *testing.T) {
+	proxy := NewProxyHttpServer()
+	proxy.OnRequest().HandleConnect(FuncHttpsHandler(func(host string, ctx *ProxyCtx) (*ConnectAction, string) {
+		return MitmConnect, host
+	}))
+	proxy.ConnectDial = func(network, addr string) (net.Conn, error) {
+		t.Fatal("dialUpstream should not run for an action this tunnel can't serve")
+		return nil, nil
+	}
+
+	req := httptest.NewRequest(http.MethodConnect, "/", nil)
+	req.ProtoMajor = 2
+	req.Host = "example.com:443"
+	w := httptest.NewRecorder()
+
+	proxy.tunnelExtendedConnect(w, req)
+
+	if w.Code != http.StatusBadGateway {
+		t.Errorf("status = %d, want %d", w.Code, http.StatusBadGateway)
+	}
+}
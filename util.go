@@ -40,6 +40,29 @@ func (w *connResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	return conn, rw, nil
 }
 
+// Read lets connResponseWriter double as the read side of a CONNECT tunnel,
+// so it can satisfy TunnelWriter in addition to http.ResponseWriter.
+func (w *connResponseWriter) Read(p []byte) (int, error) {
+	conn, ok := w.dst.(net.Conn)
+	if !ok {
+		return 0, errors.New("proxy: nested io.Writer does not implement net.Conn interface")
+	}
+	return conn.Read(p)
+}
+
+// CloseWrite half-closes the underlying connection's write side, signalling
+// the other end that no more tunneled data is coming.
+func (w *connResponseWriter) CloseWrite() error {
+	conn, ok := w.dst.(net.Conn)
+	if !ok {
+		return errors.New("proxy: nested io.Writer does not implement net.Conn interface")
+	}
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return conn.Close()
+}
+
 func NewConnResponseWriter(dst io.Writer) *connResponseWriter {
 	return &connResponseWriter{
 		dst: dst,